@@ -0,0 +1,475 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+// Adjacency represents a single entry of adjacencies.csv.
+type Adjacency struct {
+	Line     int // Line in adjacencies.csv.
+	From     *Province
+	To       *Province
+	Type     string      // "" for land, "sea", "impassable", "canal" or "lake".
+	Through  *Province   // Province the crossing goes through, nil if not set.
+	Start    image.Point // Pixel coordinates in provinces.bmp.
+	Stop     image.Point
+	HasStart bool // Both start coordinates were set, otherwise the province center is used.
+	HasStop  bool
+	RuleName string
+	Rule     *AdjacencyRule // Nil if RuleName is empty or unknown.
+	Comment  string
+}
+
+// IsConnection reports whether units can cross the adjacency.
+// Unknown types are not treated as connections.
+func (a *Adjacency) IsConnection() bool {
+	switch a.Type {
+	case "", "sea", "canal", "lake":
+		return true
+	}
+	return false
+}
+
+// AdjacencyRule represents an adjacency_rule of adjacency_rules.txt
+// that controls who can use a crossing like a strait or a canal.
+type AdjacencyRule struct {
+	Name              string
+	Pos               filePos
+	Contested         AdjacencyAccess
+	Enemy             AdjacencyAccess
+	Friend            AdjacencyAccess
+	Neutral           AdjacencyAccess
+	RequiredProvinces []int
+	Icon              int
+}
+
+// AdjacencyAccess lists the unit types that can use a crossing.
+type AdjacencyAccess struct {
+	Army      bool
+	Navy      bool
+	Submarine bool
+	Trade     bool
+}
+
+var adjacencies []*Adjacency
+var adjacencyRulesMap = make(map[string]*AdjacencyRule)
+
+func parseAdjacencyRules() error {
+	fmt.Printf("%s: Parsing adjacency_rules.txt...\n", time.Since(startTime))
+	_, err := os.Stat(filepath.FromSlash(adjacencyRulesPath))
+	if os.IsNotExist(err) {
+		fmt.Printf("%s: No adjacency_rules.txt found, adjacency rules are not resolved\n", time.Since(startTime))
+		return nil
+	}
+	root, err := parseScriptFile(filepath.FromSlash(adjacencyRulesPath))
+	if err != nil {
+		return err
+	}
+	for _, n := range root.FindAll("adjacency_rule") {
+		rule, err := parseAdjacencyRule(n)
+		if err != nil {
+			return err
+		}
+		adjacencyRulesMap[rule.Name] = &rule
+	}
+	return nil
+}
+
+func parseAdjacencyRule(n *scriptNode) (rule AdjacencyRule, err error) {
+	if !n.IsBlock {
+		return rule, newFileError(n.Pos, "adjacency_rule: expected block")
+	}
+	rule.Pos = n.Pos
+
+	name := n.Find("name")
+	if name == nil {
+		return rule, newFileError(n.Pos, "adjacency_rule has no name")
+	}
+	rule.Name = name.Value
+
+	for _, access := range []struct {
+		key string
+		dst *AdjacencyAccess
+	}{
+		{"contested", &rule.Contested},
+		{"enemy", &rule.Enemy},
+		{"friend", &rule.Friend},
+		{"neutral", &rule.Neutral},
+	} {
+		if v := n.Find(access.key); v != nil {
+			*access.dst, err = parseAdjacencyAccess(v)
+			if err != nil {
+				return rule, err
+			}
+		}
+	}
+
+	if v := n.Find("required_provinces"); v != nil {
+		rule.RequiredProvinces, err = v.Ints()
+		if err != nil {
+			return rule, err
+		}
+	}
+	if v := n.Find("icon"); v != nil {
+		rule.Icon, err = v.Int()
+		if err != nil {
+			return rule, err
+		}
+	}
+	return rule, nil
+}
+
+func parseAdjacencyAccess(n *scriptNode) (access AdjacencyAccess, err error) {
+	if !n.IsBlock {
+		return access, newFileError(n.Pos, "%v: expected block", n.Key)
+	}
+	for _, field := range []struct {
+		key string
+		dst *bool
+	}{
+		{"army", &access.Army},
+		{"navy", &access.Navy},
+		{"submarine", &access.Submarine},
+		{"trade", &access.Trade},
+	} {
+		if v := n.Find(field.key); v != nil {
+			*field.dst, err = v.Bool()
+			if err != nil {
+				return access, err
+			}
+		}
+	}
+	return access, nil
+}
+
+// ParseAdjacencies reads adjacencies.csv up to the "-1" terminator line.
+// The header is detected by its non-numeric first field, and comments,
+// blank lines and lines after the terminator are skipped.
+func parseAdjacencies() error {
+	fmt.Printf("%s: Parsing adjacencies.csv...\n", time.Since(startTime))
+	lines, err := readLines(filepath.FromSlash(adjacenciesPath))
+	if err != nil {
+		return err
+	}
+
+	firstEntry := true
+	for i, s := range lines {
+		pos := filePos{Path: adjacenciesPath, Line: i + 1}
+
+		// Skip commented and empty lines.
+		if strings.HasPrefix(s, "#") || strings.TrimSpace(s) == "" {
+			continue
+		}
+
+		f := strings.Split(s, ";")
+		from := strings.TrimSpace(f[0])
+		if firstEntry {
+			firstEntry = false
+			if _, err := strconv.Atoi(from); err != nil {
+				// Header like "From;To;Type;Through;start_x;start_y;stop_x;stop_y;adjacency_rule_name;Comment".
+				continue
+			}
+		}
+
+		// The game stops reading at the terminator line.
+		if from == "-1" {
+			for j := i + 1; j < len(lines); j++ {
+				if !strings.HasPrefix(lines[j], "#") && strings.TrimSpace(lines[j]) != "" {
+					addValidationIssue(validationIssue{Check: "adjacencies", Pos: filePos{Path: adjacenciesPath, Line: j + 1}, Message: "entry after the -1 terminator line is ignored"})
+				}
+			}
+			break
+		}
+
+		a, err := parseAdjacency(pos, f)
+		if err != nil {
+			err = lenientError("adjacencies", err)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if a.From == nil || a.To == nil {
+			// Unknown provinces were reported in lenient mode.
+			continue
+		}
+		adjacencies = append(adjacencies, a)
+
+		a.From.Adjacencies = append(a.From.Adjacencies, a)
+		a.To.Adjacencies = append(a.To.Adjacencies, a)
+		if a.IsConnection() {
+			a.From.ConnectedTo[a.To.ID] = a.To
+			a.To.ConnectedTo[a.From.ID] = a.From
+		} else if a.Type == "impassable" {
+			a.From.ImpassableTo[a.To.ID] = a.To
+			a.To.ImpassableTo[a.From.ID] = a.From
+		}
+	}
+	return nil
+}
+
+// AdjacencyFields are the names of the adjacencies.csv columns.
+var adjacencyFields = []string{"from", "to", "type", "through", "start_x", "start_y", "stop_x", "stop_y", "adjacency_rule_name", "comment"}
+
+func parseAdjacency(pos filePos, f []string) (*Adjacency, error) {
+	if len(f) < 3 {
+		return nil, newFileError(pos, "expected at least 3 fields, found %v", len(f))
+	}
+	// Missing fields are taken as empty, semicolons in the comment add fields.
+	if len(f) != len(adjacencyFields) {
+		addValidationIssue(validationIssue{Check: "adjacencies", Pos: pos, Message: fmt.Sprintf("expected %v fields, found %v", len(adjacencyFields), len(f))})
+	}
+	if len(f) > len(adjacencyFields) {
+		f = append(f[:len(adjacencyFields)-1:len(adjacencyFields)-1], strings.Join(f[len(adjacencyFields)-1:], ";"))
+	}
+	for len(f) < len(adjacencyFields) {
+		f = append(f, "")
+	}
+
+	// Province IDs and coordinates, -1 if empty.
+	numbers := make(map[int]int)
+	for _, i := range []int{0, 1, 3, 4, 5, 6, 7} {
+		v := strings.TrimSpace(f[i])
+		if v == "" {
+			numbers[i] = -1
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, newFileError(pos, "%v: expected integer, found %q", adjacencyFields[i], f[i])
+		}
+		numbers[i] = n
+	}
+
+	// Coordinates are measured from the bottom left corner of the map.
+	ruleName := strings.TrimSpace(f[8])
+	a := &Adjacency{
+		Line:     pos.Line,
+		From:     provincesIDMap[numbers[0]],
+		To:       provincesIDMap[numbers[1]],
+		Type:     strings.TrimSpace(f[2]),
+		Through:  provincesIDMap[numbers[3]],
+		Start:    image.Point{numbers[4], provincesImageSize.Dy() - 1 - numbers[5]},
+		Stop:     image.Point{numbers[6], provincesImageSize.Dy() - 1 - numbers[7]},
+		HasStart: numbers[4] != -1 && numbers[5] != -1,
+		HasStop:  numbers[6] != -1 && numbers[7] != -1,
+		RuleName: ruleName,
+		Rule:     adjacencyRulesMap[ruleName],
+		Comment:  f[9],
+	}
+
+	for _, i := range []int{0, 1} {
+		if provincesIDMap[numbers[i]] == nil {
+			err := reportIssue(validationIssue{Check: "adjacencies", Pos: pos, Message: fmt.Sprintf("unknown %v province %v", adjacencyFields[i], numbers[i])})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	// The game ignores an unknown through province, so it is only reported by "validate adjacencies".
+	if a.Through == nil && numbers[3] != -1 {
+		addValidationIssue(validationIssue{Check: "adjacencies", Pos: pos, Message: fmt.Sprintf("unknown through province %v", numbers[3])})
+	}
+	// A point with a single coordinate set is ignored like an unset one.
+	for _, point := range []struct {
+		name string
+		x, y int
+	}{
+		{"start", numbers[4], numbers[5]},
+		{"stop", numbers[6], numbers[7]},
+	} {
+		if (point.x == -1) != (point.y == -1) {
+			issue := validationIssue{Check: "adjacencies", Pos: pos, IDs: []int{numbers[0], numbers[1]}, Message: fmt.Sprintf("partial %v coordinates %v;%v", point.name, point.x, point.y)}
+			addValidationIssue(issue)
+			// Validate runs list the issue in the report instead.
+			if !lenientParsing {
+				fmt.Printf("%s: %v, the province center is used\n", time.Since(startTime), issue)
+			}
+		}
+	}
+	if !a.IsConnection() && a.Type != "impassable" {
+		issue := validationIssue{Check: "adjacencies", Pos: pos, IDs: []int{numbers[0], numbers[1]}, Message: fmt.Sprintf("unknown type %q", a.Type)}
+		addValidationIssue(issue)
+		// Validate runs list the issue in the report instead.
+		if !lenientParsing {
+			fmt.Printf("%s: %v, the adjacency is not a connection\n", time.Since(startTime), issue)
+		}
+	}
+	return a, nil
+}
+
+func validateAdjacencies(fileName string, render bool) error {
+	fmt.Printf("%s: Validating adjacencies.csv...\n", time.Since(startTime))
+	issues := issuesOf("adjacencies")
+
+	type provincePair struct{ a, b int }
+	pairs := make(map[provincePair]*Adjacency)
+	for _, a := range adjacencies {
+		pos := filePos{Path: adjacenciesPath, Line: a.Line}
+		ids := []int{a.From.ID, a.To.ID}
+
+		if a.Type == "sea" {
+			if a.Through == nil {
+				issues = append(issues, validationIssue{Check: "adjacencies", Pos: pos, IDs: ids, Message: "sea adjacency has no through province"})
+			} else if a.Through.Type != "sea" {
+				issues = append(issues, validationIssue{Check: "adjacencies", Pos: pos, IDs: append(ids, a.Through.ID), Message: fmt.Sprintf("through province %v is a %v province", a.Through.ID, a.Through.Type)})
+			}
+		}
+
+		if a.HasStart && !a.From.PixelCoordsMap[a.Start] {
+			issues = append(issues, validationIssue{Check: "adjacencies", Pos: pos, IDs: ids, Points: []image.Point{a.Start}, Message: fmt.Sprintf("start is outside of province %v", a.From.ID)})
+		}
+		if a.HasStop && !a.To.PixelCoordsMap[a.Stop] {
+			issues = append(issues, validationIssue{Check: "adjacencies", Pos: pos, IDs: ids, Points: []image.Point{a.Stop}, Message: fmt.Sprintf("stop is outside of province %v", a.To.ID)})
+		}
+
+		if a.Type == "impassable" && a.From.AdjacentTo[a.To.ID] == nil {
+			issues = append(issues, validationIssue{Check: "adjacencies", Pos: pos, IDs: ids, Message: fmt.Sprintf("impassable provinces %v and %v do not share a border", a.From.ID, a.To.ID)})
+		}
+
+		if a.RuleName != "" && a.Rule == nil {
+			issues = append(issues, validationIssue{Check: "adjacencies", Pos: pos, IDs: ids, Message: fmt.Sprintf("unknown adjacency rule %q", a.RuleName)})
+		}
+
+		pair := provincePair{a.From.ID, a.To.ID}
+		if pair.a > pair.b {
+			pair.a, pair.b = pair.b, pair.a
+		}
+		if first, ok := pairs[pair]; ok {
+			issues = append(issues, validationIssue{Check: "adjacencies", Pos: pos, IDs: ids, Message: fmt.Sprintf("duplicate adjacency of provinces %v and %v, first defined on line %v", pair.a, pair.b, first.Line)})
+		} else {
+			pairs[pair] = a
+		}
+	}
+
+	return saveValidationIssues("adjacencies", fileName, render, issues)
+}
+
+func containsAdjacency(s []*Adjacency, a *Adjacency) bool {
+	for _, b := range s {
+		if a == b {
+			return true
+		}
+	}
+	return false
+}
+
+func generateAdjacencyMap() error {
+	fmt.Printf("%s: Generating adjacency map...\n", time.Since(startTime))
+
+	// Create empty image and fill it with blue color (water).
+	img := image.NewRGBA(provincesImageSize)
+	draw.Draw(img, img.Bounds(), &image.Uniform{waterColor}, image.ZP, draw.Src)
+
+	// Draw land province shapes.
+	fillCol := color.RGBA{255, 255, 255, 255}
+	for _, prov := range provincesIDMap {
+		if prov.Type == "land" {
+			for _, p := range prov.PixelCoords {
+				img.Set(p.X, p.Y, fillCol)
+			}
+		}
+	}
+
+	// Draw province borders.
+	provinceBorderColor := color.RGBA{200, 200, 200, 255}
+	for _, prov := range provincesIDMap {
+		for _, p := range prov.PixelCoords {
+			if !prov.PixelCoordsMap[image.Point{p.X + 1, p.Y}] {
+				img.Set(p.X+1, p.Y, provinceBorderColor)
+			}
+			if !prov.PixelCoordsMap[image.Point{p.X, p.Y + 1}] {
+				img.Set(p.X, p.Y+1, provinceBorderColor)
+			}
+		}
+	}
+
+	// Draw adjacencies from start to stop, or between province centers.
+	for _, a := range adjacencies {
+		if a.From == nil || a.To == nil {
+			continue
+		}
+		start, stop := a.From.CenterPoint, a.To.CenterPoint
+		if a.HasStart {
+			start = a.Start
+		}
+		if a.HasStop {
+			stop = a.Stop
+		}
+		drawLine(img, start, stop, adjacencyColor(a))
+	}
+
+	// Save image as PNG.
+	out, err := os.Create(filepath.Join(outputPath, "adjacency_map.png"))
+	if err != nil {
+		return err
+	}
+	err = png.Encode(out, img)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: Saved 'adjacency_map.png'\n", time.Since(startTime))
+	return nil
+}
+
+// AdjacencyColor returns magenta for rule-controlled crossings
+// and a color for each adjacency type otherwise.
+func adjacencyColor(a *Adjacency) color.RGBA {
+	if a.RuleName != "" {
+		return color.RGBA{255, 0, 255, 255}
+	}
+	switch a.Type {
+	case "":
+		return color.RGBA{0, 160, 0, 255}
+	case "sea":
+		return color.RGBA{255, 0, 0, 255}
+	case "impassable":
+		return color.RGBA{0, 0, 0, 255}
+	}
+	return color.RGBA{255, 140, 0, 255}
+}
+
+// DrawLine draws a one pixel wide line from p1 to p2.
+func drawLine(img *image.RGBA, p1, p2 image.Point, c color.RGBA) {
+	dx, dy := abs(p2.X-p1.X), -abs(p2.Y-p1.Y)
+	sx, sy := 1, 1
+	if p1.X > p2.X {
+		sx = -1
+	}
+	if p1.Y > p2.Y {
+		sy = -1
+	}
+	e := dx + dy
+	for {
+		img.Set(p1.X, p1.Y, c)
+		if p1 == p2 {
+			return
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			p1.X += sx
+		}
+		if e2 <= dx {
+			e += dx
+			p1.Y += sy
+		}
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
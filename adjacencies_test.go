@@ -0,0 +1,388 @@
+package main
+
+import (
+	"image"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// SetupAdjacencyTest replaces the parsed map data with land provinces 1 and 2
+// and sea province 3 on a 10x10 map.
+func setupAdjacencyTest(t *testing.T, lenient bool) {
+	oldProvinces, oldSize, oldLenient := provincesIDMap, provincesImageSize, lenientParsing
+	t.Cleanup(func() {
+		provincesIDMap, provincesImageSize, lenientParsing = oldProvinces, oldSize, oldLenient
+		adjacencies, validationIssues = nil, nil
+	})
+
+	provincesIDMap = make(map[int]*Province)
+	for id, typ := range map[int]string{1: "land", 2: "land", 3: "sea"} {
+		provincesIDMap[id] = &Province{
+			ID:           id,
+			Type:         typ,
+			AdjacentTo:   make(map[int]*Province),
+			ConnectedTo:  make(map[int]*Province),
+			ImpassableTo: make(map[int]*Province),
+		}
+	}
+	provincesImageSize = image.Rect(0, 0, 10, 10)
+	lenientParsing = lenient
+	adjacencies, validationIssues = nil, nil
+}
+
+func issueMessages() (messages []string) {
+	for _, i := range validationIssues {
+		messages = append(messages, i.String())
+	}
+	return messages
+}
+
+func TestParseAdjacency(t *testing.T) {
+	tests := []struct {
+		line    string
+		lenient bool
+		want    Adjacency // From, To and Through hold province IDs in their ID field.
+		err     string
+		issues  []string
+	}{
+		{
+			line: "1;2;sea;3;-1;-1;-1;-1;;",
+			want: Adjacency{From: &Province{ID: 1}, To: &Province{ID: 2}, Type: "sea", Through: &Province{ID: 3}},
+		},
+		{
+			line: " 1 ; 2 ; impassable ;;;;;;;mountains",
+			want: Adjacency{From: &Province{ID: 1}, To: &Province{ID: 2}, Type: "impassable", Comment: "mountains"},
+		},
+		{
+			// Coordinates are measured from the bottom left corner.
+			line: "1;2;;-1;3;0;4;9;STRAIT;",
+			want: Adjacency{From: &Province{ID: 1}, To: &Province{ID: 2}, Start: image.Point{3, 9}, Stop: image.Point{4, 0}, HasStart: true, HasStop: true, RuleName: "STRAIT"},
+		},
+		{
+			line:   "1;2;impassable;-1;-1;-1;-1;-1",
+			want:   Adjacency{From: &Province{ID: 1}, To: &Province{ID: 2}, Type: "impassable"},
+			issues: []string{"a.csv:7: expected 10 fields, found 8"},
+		},
+		{
+			line:   "1;2;sea;3;-1;-1;-1;-1;;a; b;c",
+			want:   Adjacency{From: &Province{ID: 1}, To: &Province{ID: 2}, Type: "sea", Through: &Province{ID: 3}, Comment: "a; b;c"},
+			issues: []string{"a.csv:7: expected 10 fields, found 12"},
+		},
+		{
+			line: "1;2",
+			err:  "a.csv:7: expected at least 3 fields, found 2",
+		},
+		{
+			line: "1;x;sea;3;-1;-1;-1;-1;;",
+			err:  `a.csv:7: to: expected integer, found "x"`,
+		},
+		{
+			line: "1;2;sea;3;-1;1.5;-1;-1;;",
+			err:  `a.csv:7: start_y: expected integer, found "1.5"`,
+		},
+		{
+			line: "1;99;sea;3;-1;-1;-1;-1;;",
+			err:  "a.csv:7: unknown to province 99",
+		},
+		{
+			line:    "98;2;sea;3;-1;-1;-1;-1;;",
+			lenient: true,
+			want:    Adjacency{To: &Province{ID: 2}, Type: "sea", Through: &Province{ID: 3}},
+			issues:  []string{"a.csv:7: unknown from province 98"},
+		},
+		{
+			line:   "1;2;;-1;3;-1;-1;-1;;",
+			want:   Adjacency{From: &Province{ID: 1}, To: &Province{ID: 2}},
+			issues: []string{"a.csv:7: partial start coordinates 3;-1 [provinces 1, 2]"},
+		},
+		{
+			line:   "1;2;;-1;3;0;-1;4;;",
+			want:   Adjacency{From: &Province{ID: 1}, To: &Province{ID: 2}, Start: image.Point{3, 9}, HasStart: true},
+			issues: []string{"a.csv:7: partial stop coordinates -1;4 [provinces 1, 2]"},
+		},
+		{
+			line:   "1;2;bridge;-1;-1;-1;-1;-1;;",
+			want:   Adjacency{From: &Province{ID: 1}, To: &Province{ID: 2}, Type: "bridge"},
+			issues: []string{`a.csv:7: unknown type "bridge" [provinces 1, 2]`},
+		},
+		{
+			line:   "1;2;sea;97;-1;-1;-1;-1;;",
+			want:   Adjacency{From: &Province{ID: 1}, To: &Province{ID: 2}, Type: "sea"},
+			issues: []string{"a.csv:7: unknown through province 97"},
+		},
+	}
+
+	id := func(p *Province) int {
+		if p == nil {
+			return -1
+		}
+		return p.ID
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			setupAdjacencyTest(t, tt.lenient)
+
+			a, err := parseAdjacency(filePos{Path: "a.csv", Line: 7}, strings.Split(tt.line, ";"))
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				if _, ok := err.(*fileError); !ok {
+					t.Errorf("got %T, want *fileError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if a.Line != 7 || id(a.From) != id(tt.want.From) || id(a.To) != id(tt.want.To) || id(a.Through) != id(tt.want.Through) {
+				t.Errorf("got line %v, provinces %v %v through %v", a.Line, id(a.From), id(a.To), id(a.Through))
+			}
+			if a.Type != tt.want.Type || a.RuleName != tt.want.RuleName || a.Comment != tt.want.Comment {
+				t.Errorf("got type %q, rule %q, comment %q", a.Type, a.RuleName, a.Comment)
+			}
+			if a.HasStart != tt.want.HasStart || a.HasStop != tt.want.HasStop ||
+				(a.HasStart && a.Start != tt.want.Start) || (a.HasStop && a.Stop != tt.want.Stop) {
+				t.Errorf("got start %v (%v), stop %v (%v)", a.Start, a.HasStart, a.Stop, a.HasStop)
+			}
+			if got := strings.Join(issueMessages(), "\n"); got != strings.Join(tt.issues, "\n") {
+				t.Errorf("got issues %q, want %q", got, tt.issues)
+			}
+		})
+	}
+}
+
+func TestAdjacencyConnections(t *testing.T) {
+	tests := []struct {
+		typ        string
+		connected  bool
+		impassable bool
+	}{
+		{typ: "", connected: true},
+		{typ: "sea", connected: true},
+		{typ: "canal", connected: true},
+		{typ: "lake", connected: true},
+		{typ: "impassable", impassable: true},
+		{typ: "bridge"},
+		{typ: "Sea"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.typ, func(t *testing.T) {
+			setupAdjacencyTest(t, false)
+			dir := t.TempDir()
+			oldPath := adjacenciesPath
+			defer func() { adjacenciesPath = oldPath }()
+			adjacenciesPath = filepath.ToSlash(filepath.Join(dir, "a.csv"))
+			err := ioutil.WriteFile(filepath.FromSlash(adjacenciesPath), []byte("1;2;"+tt.typ+";-1;-1;-1;-1;-1;;\n"), 0664)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = parseAdjacencies()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := (&Adjacency{Type: tt.typ}).IsConnection(); got != tt.connected {
+				t.Errorf("IsConnection: got %v, want %v", got, tt.connected)
+			}
+			p1, p2 := provincesIDMap[1], provincesIDMap[2]
+			if (p1.ConnectedTo[2] != nil) != tt.connected || (p2.ConnectedTo[1] != nil) != tt.connected {
+				t.Errorf("got connected %v %v, want %v", p1.ConnectedTo, p2.ConnectedTo, tt.connected)
+			}
+			if (p1.ImpassableTo[2] != nil) != tt.impassable || (p2.ImpassableTo[1] != nil) != tt.impassable {
+				t.Errorf("got impassable %v %v, want %v", p1.ImpassableTo, p2.ImpassableTo, tt.impassable)
+			}
+			if len(p1.Adjacencies) != 1 || len(p2.Adjacencies) != 1 {
+				t.Errorf("got %v and %v adjacencies, want 1", len(p1.Adjacencies), len(p2.Adjacencies))
+			}
+		})
+	}
+}
+
+func TestParseAdjacencies(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		lenient bool
+		lines   []int // Lines of the parsed adjacencies.
+		err     string
+		issues  []string
+	}{
+		{
+			name:  "header and terminator",
+			src:   "From;To;Type;Through;start_x;start_y;stop_x;stop_y;adjacency_rule_name;Comment\n1;2;impassable;-1;-1;-1;-1;-1;;\n-1;-1;;-1;-1;-1;-1;-1;;\n",
+			lines: []int{2},
+		},
+		{
+			name:  "no header, comments and blank lines",
+			src:   "# adjacencies\n\n1;2;impassable;-1;-1;-1;-1;-1;;\r\n\n1;3;;-1;-1;-1;-1;-1;;\n",
+			lines: []int{3, 5},
+		},
+		{
+			name:   "entries after the terminator",
+			src:    "From;To\n1;2;impassable;-1;-1;-1;-1;-1;;\n-1;-1;;-1;-1;-1;-1;-1;;\n\n1;3;;-1;-1;-1;-1;-1;;\n# comment\n2;3;;-1;-1;-1;-1;-1;;\n",
+			lines:  []int{2},
+			issues: []string{"/a.csv:5: entry after the -1 terminator line is ignored", "/a.csv:7: entry after the -1 terminator line is ignored"},
+		},
+		{
+			name: "parse error",
+			src:  "From;To\n1;2;impassable;-1;-1;-1;-1;-1;;\n1;x;;-1;-1;-1;-1;-1;;\n",
+			err:  `/a.csv:3: to: expected integer, found "x"`,
+		},
+		{
+			name:    "parse error in lenient mode",
+			src:     "From;To\n1;x;;-1;-1;-1;-1;-1;;\n1;99;;-1;-1;-1;-1;-1;;\n1;2;impassable;-1;-1;-1;-1;-1;;\n",
+			lenient: true,
+			lines:   []int{4},
+			issues:  []string{`/a.csv:2: to: expected integer, found "x"`, "/a.csv:3: unknown to province 99"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupAdjacencyTest(t, tt.lenient)
+			dir := t.TempDir()
+			oldPath := adjacenciesPath
+			defer func() { adjacenciesPath = oldPath }()
+			adjacenciesPath = filepath.ToSlash(filepath.Join(dir, "a.csv"))
+			err := ioutil.WriteFile(filepath.FromSlash(adjacenciesPath), []byte(tt.src), 0664)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = parseAdjacencies()
+			if tt.err != "" {
+				if err == nil || err.Error() != filepath.ToSlash(dir)+tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var lines []int
+			for _, a := range adjacencies {
+				lines = append(lines, a.Line)
+			}
+			if joinInts(lines) != joinInts(tt.lines) {
+				t.Errorf("got adjacencies on lines %v, want %v", lines, tt.lines)
+			}
+			var want []string
+			for _, s := range tt.issues {
+				want = append(want, filepath.ToSlash(dir)+s)
+			}
+			if got := strings.Join(issueMessages(), "\n"); got != strings.Join(want, "\n") {
+				t.Errorf("got issues %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestValidateAdjacencies(t *testing.T) {
+	tests := []struct {
+		name   string
+		lines  []string // Lines 2 and on of adjacencies.csv.
+		setup  func()
+		issues []string
+	}{
+		{
+			name:  "valid",
+			lines: []string{"1;2;sea;3;1;1;2;1;STRAIT;", "1;3;;-1;-1;-1;-1;-1;;", "2;3;impassable;-1;-1;-1;-1;-1;;"},
+			setup: func() { provincesIDMap[2].AdjacentTo[3] = provincesIDMap[3] },
+		},
+		{
+			name:   "duplicate",
+			lines:  []string{"1;2;sea;3;-1;-1;-1;-1;;", "1;3;;-1;-1;-1;-1;-1;;", "2;1;impassable;-1;-1;-1;-1;-1;;"},
+			setup:  func() { provincesIDMap[2].AdjacentTo[1] = provincesIDMap[1] },
+			issues: []string{"adjacencies.csv:4: duplicate adjacency of provinces 1 and 2, first defined on line 2 [provinces 2, 1]"},
+		},
+		{
+			name:   "unknown type",
+			lines:  []string{"1;2;bridge;-1;-1;-1;-1;-1;;"},
+			issues: []string{`adjacencies.csv:2: unknown type "bridge" [provinces 1, 2]`},
+		},
+		{
+			name:  "sea adjacency",
+			lines: []string{"1;2;sea;-1;-1;-1;-1;-1;;", "2;1;sea;1;-1;-1;-1;-1;;"},
+			issues: []string{
+				"adjacencies.csv:2: sea adjacency has no through province [provinces 1, 2]",
+				"adjacencies.csv:3: through province 1 is a land province [provinces 2, 1, 1]",
+				"adjacencies.csv:3: duplicate adjacency of provinces 1 and 2, first defined on line 2 [provinces 2, 1]",
+			},
+		},
+		{
+			name:  "start and stop outside of the provinces",
+			lines: []string{"1;2;;-1;5;8;1;8;;"},
+			issues: []string{
+				"adjacencies.csv:2: start is outside of province 1 [provinces 1, 2] at (5,1)",
+				"adjacencies.csv:2: stop is outside of province 2 [provinces 1, 2] at (1,1)",
+			},
+		},
+		{
+			name:   "partial start",
+			lines:  []string{"1;3;;-1;1;-1;-1;-1;;"},
+			issues: []string{"adjacencies.csv:2: partial start coordinates 1;-1 [provinces 1, 3]"},
+		},
+		{
+			name:   "impassable without a border",
+			lines:  []string{"1;2;impassable;-1;-1;-1;-1;-1;;"},
+			issues: []string{"adjacencies.csv:2: impassable provinces 1 and 2 do not share a border [provinces 1, 2]"},
+		},
+		{
+			name:   "unknown rule",
+			lines:  []string{"1;3;;-1;-1;-1;-1;-1;CANAL;"},
+			issues: []string{`adjacencies.csv:2: unknown adjacency rule "CANAL" [provinces 1, 3]`},
+		},
+		{
+			name:  "parse issues come first",
+			lines: []string{"1;3;;-1;-1;-1;-1;-1;X;", "1;9;;-1;-1;-1;-1;-1;;"},
+			issues: []string{
+				"adjacencies.csv:3: unknown to province 9",
+				`adjacencies.csv:2: unknown adjacency rule "X" [provinces 1, 3]`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupAdjacencyTest(t, true)
+			oldPath, oldRules, oldOutput := adjacenciesPath, adjacencyRulesMap, outputPath
+			defer func() { adjacenciesPath, adjacencyRulesMap, outputPath = oldPath, oldRules, oldOutput }()
+			adjacenciesPath = "adjacencies.csv"
+			adjacencyRulesMap = map[string]*AdjacencyRule{"STRAIT": {Name: "STRAIT"}}
+			outputPath = t.TempDir()
+			provincesIDMap[1].PixelCoordsMap = map[image.Point]bool{{1, 8}: true}
+			provincesIDMap[2].PixelCoordsMap = map[image.Point]bool{{2, 8}: true}
+
+			for i, l := range tt.lines {
+				a, err := parseAdjacency(filePos{Path: adjacenciesPath, Line: i + 2}, strings.Split(l, ";"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if a.From != nil && a.To != nil {
+					adjacencies = append(adjacencies, a)
+				}
+			}
+			if tt.setup != nil {
+				tt.setup()
+			}
+
+			err := validateAdjacencies("adjacencies.txt", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := ioutil.ReadFile(filepath.Join(outputPath, "adjacencies.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.TrimSuffix(string(b), "\n"); got != strings.Join(tt.issues, "\n") {
+				t.Errorf("got issues:\n%v\nwant:\n%v", got, strings.Join(tt.issues, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"time"
+)
+
+func findProvincesCenterPoints() {
+	fmt.Printf("%s: Calculating provinces center point coordinates...\n", time.Since(startTime))
+	for _, p := range provincesIDMap {
+		p.CenterPoint = findCenterPoint(p.PixelCoords)
+	}
+}
+
+// FindCenterPoint returns the label position of an area
+// using the method selected with the -center flag.
+func findCenterPoint(coords []image.Point) image.Point {
+	if len(coords) == 0 {
+		return image.Point{}
+	}
+	if centerMethod == "mean" {
+		return findMeanPoint(coords)
+	}
+	return findPoleOfInaccessibility(coords)
+}
+
+// FindMeanPoint returns the average of the pixel coordinates.
+// It can lie outside of crescent or ring-shaped areas.
+func findMeanPoint(coords []image.Point) image.Point {
+	x := 0
+	y := 0
+	for _, c := range coords {
+		x += c.X
+		y += c.Y
+	}
+	return image.Point{int(math.Round(float64(x) / float64(len(coords)))), int(math.Round(float64(y) / float64(len(coords))))}
+}
+
+// FindPoleOfInaccessibility returns the pixel of the area farthest from its border,
+// which is always one of the coords. Distances are found with a two-pass
+// 3-4 chamfer distance transform over the bounding box of the area.
+// Ties are resolved by the distance to the mean point, so compact areas
+// keep the center they had with the mean method.
+func findPoleOfInaccessibility(coords []image.Point) image.Point {
+	bounds := boundingBox(coords)
+	// Keep a one pixel wide empty frame around the area,
+	// so the transform never has to check the grid edges.
+	w := bounds.Dx() + 2
+	h := bounds.Dy() + 2
+	const outside = 0
+	const inside = math.MaxInt32 / 2
+	dist := make([]int32, w*h)
+	for _, c := range coords {
+		dist[(c.Y-bounds.Min.Y+1)*w+c.X-bounds.Min.X+1] = inside
+	}
+
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			i := y*w + x
+			if dist[i] == outside {
+				continue
+			}
+			dist[i] = minInt32(dist[i], dist[i-1]+3, dist[i-w]+3, dist[i-w-1]+4, dist[i-w+1]+4)
+		}
+	}
+	for y := h - 2; y >= 1; y-- {
+		for x := w - 2; x >= 1; x-- {
+			i := y*w + x
+			if dist[i] == outside {
+				continue
+			}
+			dist[i] = minInt32(dist[i], dist[i+1]+3, dist[i+w]+3, dist[i+w+1]+4, dist[i+w-1]+4)
+		}
+	}
+
+	mean := findMeanPoint(coords)
+	best := coords[0]
+	bestDist := int32(-1)
+	bestMeanDist := 0
+	for _, c := range coords {
+		d := dist[(c.Y-bounds.Min.Y+1)*w+c.X-bounds.Min.X+1]
+		dx, dy := c.X-mean.X, c.Y-mean.Y
+		md := dx*dx + dy*dy
+		if d > bestDist || (d == bestDist && md < bestMeanDist) {
+			best = c
+			bestDist = d
+			bestMeanDist = md
+		}
+	}
+	return best
+}
+
+func minInt32(a int32, b ...int32) int32 {
+	for _, v := range b {
+		if v < a {
+			a = v
+		}
+	}
+	return a
+}
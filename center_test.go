@@ -0,0 +1,91 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+// RectCoords returns the pixel coordinates of the rectangles.
+func rectCoords(rects ...image.Rectangle) (coords []image.Point) {
+	for _, r := range rects {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				coords = append(coords, image.Point{x, y})
+			}
+		}
+	}
+	return coords
+}
+
+func TestFindPoleOfInaccessibility(t *testing.T) {
+	// A 9x9 square with a 5x5 hole in the middle.
+	ring := rectCoords(
+		image.Rect(0, 0, 9, 2),
+		image.Rect(0, 7, 9, 9),
+		image.Rect(0, 2, 2, 7),
+		image.Rect(7, 2, 9, 7),
+	)
+	// An open ring with its mean point in the empty middle.
+	crescent := rectCoords(
+		image.Rect(0, 0, 12, 3),
+		image.Rect(0, 9, 12, 12),
+		image.Rect(0, 3, 3, 9),
+	)
+
+	tests := []struct {
+		name   string
+		coords []image.Point
+		want   *image.Point // Nil when any pixel of the area will do.
+	}{
+		{name: "single pixel", coords: rectCoords(image.Rect(5, 7, 6, 8)), want: &image.Point{5, 7}},
+		{name: "rectangle", coords: rectCoords(image.Rect(10, 20, 15, 23)), want: &image.Point{12, 21}},
+		{name: "square", coords: rectCoords(image.Rect(0, 0, 4, 4)), want: &image.Point{2, 2}},
+		{
+			name:   "dumbbell",
+			coords: rectCoords(image.Rect(0, 0, 9, 9), image.Rect(9, 4, 16, 5), image.Rect(16, 2, 21, 7)),
+			want:   &image.Point{4, 4},
+		},
+		{name: "ring", coords: ring},
+		{name: "crescent", coords: crescent},
+	}
+
+	for _, tt := range tests {
+		got := findPoleOfInaccessibility(tt.coords)
+		if tt.want != nil && got != *tt.want {
+			t.Errorf("%v: got %v, want %v", tt.name, got, *tt.want)
+		}
+		inside := false
+		for _, c := range tt.coords {
+			if c == got {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			t.Errorf("%v: %v is outside of the area", tt.name, got)
+		}
+	}
+}
+
+func TestFindCenterPoint(t *testing.T) {
+	oldMethod := centerMethod
+	defer func() { centerMethod = oldMethod }()
+
+	coords := rectCoords(image.Rect(0, 0, 12, 3), image.Rect(0, 9, 12, 12), image.Rect(0, 3, 3, 9))
+	tests := []struct {
+		method string
+		coords []image.Point
+		want   image.Point
+	}{
+		{"mean", coords, image.Point{5, 6}},
+		{"mean", nil, image.Point{}},
+		{"pole", nil, image.Point{}},
+		{"pole", rectCoords(image.Rect(1, 1, 4, 4)), image.Point{2, 2}},
+	}
+	for _, tt := range tests {
+		centerMethod = tt.method
+		if got := findCenterPoint(tt.coords); got != tt.want {
+			t.Errorf("%v, %v pixels: got %v, want %v", tt.method, len(tt.coords), got, tt.want)
+		}
+	}
+}
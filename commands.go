@@ -0,0 +1,332 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Command represents a single output that can be selected on the command line.
+// Commands are written as "<group> <name> [flags]", for example "render manpower".
+type command struct {
+	Group   string
+	Name    string
+	Usage   string
+	Flags   *flag.FlagSet
+	Run     func() error
+	Check   func() error  // Optional flag value check, run after the flags are parsed.
+	Output  func() string // Optional path of the written file inside the output folder.
+	Lenient bool          // The command reports broken input files instead of failing on them.
+}
+
+func newCommand(group, name, usage string) *command {
+	c := &command{Group: group, Name: name, Usage: usage}
+	c.Flags = flag.NewFlagSet(group+" "+name, flag.ContinueOnError)
+	// Parse errors are returned and reported together with the global usage message.
+	c.Flags.SetOutput(ioutil.Discard)
+	return c
+}
+
+// NewCommands returns every available command
+// in the order they are listed in the usage message.
+func newCommands() []*command {
+	var commands []*command
+
+	c := newCommand("export", "geodata", "write on_actions file with state impassable_to@ variables")
+	c.Run = saveGeoData
+	c.Output = func() string { return geoDataFileName }
+	commands = append(commands, c)
+
+	c = newCommand("export", "script", "write a script file from a built-in template or a template file")
+	templateName := c.Flags.String("template", "geodata", "built-in template name or path to a Go text/template file")
+	scriptFileName := c.Flags.String("o", "", "output file path inside the output folder, taken from the template name if empty")
+	provinceTypes := c.Flags.String("province-types", "", "comma separated province types passed to the template like \"land,sea\", all types if empty")
+	c.Run = func() error {
+		var types []string
+		if *provinceTypes != "" {
+			types = strings.Split(*provinceTypes, ",")
+		}
+		return saveScript(*templateName, scriptOutput(*templateName, *scriptFileName), types)
+	}
+	c.Output = func() string { return scriptOutput(*templateName, *scriptFileName) }
+	commands = append(commands, c)
+
+	c = newCommand("export", "json", "write all provinces, states and strategic regions into a JSON file")
+	jsonFileName := c.Flags.String("o", "hoi4geoparser_data.json", "output file name")
+	indent := c.Flags.Bool("indent", false, "indent the JSON output")
+	c.Run = func() error { return saveGeoDataJSON(*jsonFileName, *indent) }
+	c.Output = func() string { return *jsonFileName }
+	commands = append(commands, c)
+
+	c = newCommand("render", "states", "state map with state and strategic region borders")
+	c.Run = generateSateMap
+	commands = append(commands, c)
+
+	c = newCommand("render", "states-colored", "state map with random state colors")
+	c.Run = generateColoredSateMap
+	commands = append(commands, c)
+
+	c = newCommand("render", "state-ids", "state map with state IDs")
+	c.Run = generateSateIDMap
+	commands = append(commands, c)
+
+	c = newCommand("render", "political", "state map colored by owner country with country borders")
+	labels := c.Flags.Bool("labels", false, "draw country tags")
+	c.Run = func() error { return generatePoliticalMap(*labels) }
+	commands = append(commands, c)
+
+	c = newCommand("render", "state-names", "state map with localised state names")
+	c.Run = generateSateNameMap
+	commands = append(commands, c)
+
+	c = newCommand("render", "strategic-region-ids", "strategic region map with strategic region IDs")
+	c.Run = generateStrategicRegionIDMap
+	commands = append(commands, c)
+
+	c = newCommand("render", "provinces", "province map with state and strategic region borders")
+	c.Run = generateProvinceMap
+	commands = append(commands, c)
+
+	c = newCommand("render", "province-ids", "province map with province IDs, scaled x4")
+	c.Run = generateProvinceIDMap
+	commands = append(commands, c)
+
+	c = newCommand("render", "adjacencies", "province map with adjacencies.csv entries, rule-controlled crossings in magenta")
+	c.Run = generateAdjacencyMap
+	commands = append(commands, c)
+
+	c = newCommand("render", "manpower", "state manpower map")
+	mpMin := c.Flags.Int("min", 1000, "manpower value drawn with the lowest color")
+	c.Check = func() error {
+		if *mpMin <= 0 {
+			return fmt.Errorf("-min must be positive, got %v", *mpMin)
+		}
+		return nil
+	}
+	c.Run = func() error { return generateManpowerMap(*mpMin) }
+	commands = append(commands, c)
+
+	c = newCommand("render", "sea-provinces", "sea and lake province map")
+	c.Run = generateSeaProvinceMap
+	commands = append(commands, c)
+
+	c = newCommand("render", "terrain", "province-based terrain map")
+	c.Run = generateProvinceBasedTerrainMap
+	commands = append(commands, c)
+
+	c = newCommand("render", "heightmap-threshold", "province-based heightmap threshold map")
+	threshold := c.Flags.Uint("threshold", 222, "dominant height above which a province is drawn pink")
+	spread := c.Flags.Uint("spread", 100, "height difference inside a province above which it is drawn yellow")
+	c.Check = func() error {
+		if *threshold > 255 {
+			return fmt.Errorf("-threshold must be at most 255, got %v", *threshold)
+		}
+		if *spread > 255 {
+			return fmt.Errorf("-spread must be at most 255, got %v", *spread)
+		}
+		return nil
+	}
+	c.Run = func() error {
+		return generateProvinceBasedHeightmapThresholdMap(uint8(*threshold), uint8(*spread))
+	}
+	commands = append(commands, c)
+
+	c = newCommand("render", "infrastructure", "state infrastructure map")
+	c.Run = generateInfrastructureMap
+	commands = append(commands, c)
+
+	c = newCommand("render", "building-slots", "state building slots map")
+	c.Run = generateBuildingSlotsMap
+	commands = append(commands, c)
+
+	c = newCommand("render", "resources", "state resources map")
+	resource := c.Flags.String("resource", "", "resource to draw like \"oil\", all resources are summed up if empty")
+	c.Run = func() error { return generateResourcesMap(*resource) }
+	commands = append(commands, c)
+
+	c = newCommand("render", "small-provinces", "map and list of land provinces smaller than the threshold")
+	minSize := c.Flags.Int("threshold", 32, "province size in pixels")
+	c.Run = func() error { return generateSmallProvincesMap(*minSize) }
+	commands = append(commands, c)
+
+	c = newCommand("render", "color-shuffled", "province map with new random colors and matching definition.csv")
+	c.Run = generateColorShuffledProvinceMap
+	commands = append(commands, c)
+
+	c = newCommand("render", "impassable", "impassable state mask")
+	c.Run = generateImpassableMap
+	commands = append(commands, c)
+
+	c = newCommand("fix", "definitions", "write definition.csv with recomputed coastal flags, terrain and continents and print the changed rows")
+	definitionsFileName := c.Flags.String("o", "definition.csv", "output file name")
+	recomputeCoastal := c.Flags.Bool("coastal", true, "recompute coastal flags from provinces.bmp")
+	recomputeTerrain := c.Flags.Bool("terrain", true, "recompute land province terrain from terrain.bmp")
+	fixContinents := c.Flags.String("continents", "", "path to a continents image, continents are kept if empty")
+	fixContinentColors := c.Flags.String("colors", "", "comma separated hex colors of continents 1, 2, ... in the continents image like \"4b2b07,ffffff\"")
+	c.Run = func() error {
+		return fixDefinitions(*definitionsFileName, *recomputeCoastal, *recomputeTerrain, *fixContinents, *fixContinentColors)
+	}
+	c.Output = func() string { return *definitionsFileName }
+	commands = append(commands, c)
+
+	c = newCommand("fix", "continents", "write definition.csv with continents taken from an image")
+	continentsPath := c.Flags.String("image", "continents.png", "path to the continents image")
+	continentColors := c.Flags.String("colors", "", "comma separated hex colors of continents 1, 2, ... in the continents image like \"4b2b07,ffffff\"")
+	c.Run = func() error { return fixDefinitions("definition.csv", false, false, *continentsPath, *continentColors) }
+	c.Output = func() string { return "definition.csv" }
+	commands = append(commands, c)
+
+	c = newCommand("validate", "definitions", "check definition.csv against provinces.bmp")
+	definitionsReport := c.Flags.String("o", "validation_definitions.txt", "report file name")
+	renderDefinitions := c.Flags.Bool("render", false, "draw the issues on a map next to the report")
+	c.Run = func() error { return validateDefinitions(*definitionsReport, *renderDefinitions) }
+	c.Output = func() string { return *definitionsReport }
+	c.Lenient = true
+	commands = append(commands, c)
+
+	c = newCommand("validate", "pixels", "find x-crossings, diagonal-only contacts and single-pixel islands in provinces.bmp")
+	pixelsReport := c.Flags.String("o", "validation_pixels.txt", "report file name")
+	renderPixels := c.Flags.Bool("render", false, "draw the issues on a map next to the report")
+	c.Run = func() error { return validatePixels(*pixelsReport, *renderPixels) }
+	c.Output = func() string { return *pixelsReport }
+	c.Lenient = true
+	commands = append(commands, c)
+
+	c = newCommand("validate", "contiguity", "find provinces and states split into several parts")
+	contiguityReport := c.Flags.String("o", "validation_contiguity.txt", "report file name")
+	renderContiguity := c.Flags.Bool("render", false, "draw the issues on a map next to the report")
+	c.Run = func() error { return validateContiguity(*contiguityReport, *renderContiguity) }
+	c.Output = func() string { return *contiguityReport }
+	c.Lenient = true
+	commands = append(commands, c)
+
+	c = newCommand("validate", "assignments", "check the provinces of states and strategic regions")
+	assignmentsReport := c.Flags.String("o", "validation_assignments.txt", "report file name")
+	renderAssignments := c.Flags.Bool("render", false, "draw the issues on a map next to the report")
+	c.Run = func() error { return validateAssignments(*assignmentsReport, *renderAssignments) }
+	c.Output = func() string { return *assignmentsReport }
+	c.Lenient = true
+	commands = append(commands, c)
+
+	c = newCommand("validate", "terrain", "check coastal flags and terrain of definition.csv against provinces.bmp and terrain.bmp")
+	terrainReport := c.Flags.String("o", "validation_terrain.txt", "report file name")
+	renderTerrain := c.Flags.Bool("render", false, "draw the issues on a map next to the report")
+	fixTerrain := c.Flags.Bool("fix", false, "write definition.csv with the found coastal flags and terrain into the output folder")
+	c.Run = func() error { return validateTerrain(*terrainReport, *renderTerrain, *fixTerrain) }
+	c.Output = func() string { return *terrainReport }
+	c.Lenient = true
+	commands = append(commands, c)
+
+	c = newCommand("validate", "adjacencies", "check the entries of adjacencies.csv")
+	adjacenciesReport := c.Flags.String("o", "validation_adjacencies.txt", "report file name")
+	renderAdjacencies := c.Flags.Bool("render", false, "draw the issues on a map next to the report")
+	c.Run = func() error { return validateAdjacencies(*adjacenciesReport, *renderAdjacencies) }
+	c.Output = func() string { return *adjacenciesReport }
+	c.Lenient = true
+	commands = append(commands, c)
+
+	return commands
+}
+
+// ParseCommands parses the arguments left after the global flags.
+// The group can be omitted for consecutive commands from the same group,
+// so "render manpower infrastructure" selects both render commands.
+// Every selected command gets its own flags, so the same command
+// can be selected several times with different options.
+func parseCommands(args []string) (selected []*command, err error) {
+	group := ""
+	for len(args) > 0 {
+		commands := newCommands()
+		if isCommandGroup(commands, args[0]) {
+			group = args[0]
+			args = args[1:]
+			if len(args) == 0 {
+				return nil, fmt.Errorf("%v: missing command name", group)
+			}
+		}
+		if group == "" {
+			return nil, fmt.Errorf("unknown command: %v", args[0])
+		}
+
+		c := findCommand(commands, group, args[0])
+		if c == nil {
+			return nil, fmt.Errorf("unknown command: %v %v", group, args[0])
+		}
+		err = c.Flags.Parse(args[1:])
+		if err != nil {
+			return nil, err
+		}
+		if c.Check != nil {
+			err = c.Check()
+			if err != nil {
+				return nil, fmt.Errorf("%v %v: %v", c.Group, c.Name, err)
+			}
+		}
+		args = c.Flags.Args()
+		selected = append(selected, c)
+	}
+
+	// Commands writing the same file would silently overwrite each other.
+	outputs := make(map[string]*command)
+	for _, c := range selected {
+		if c.Output == nil {
+			continue
+		}
+		path := filepath.ToSlash(filepath.Clean(filepath.FromSlash(c.Output())))
+		if first, ok := outputs[path]; ok {
+			return nil, fmt.Errorf("%v %v and %v %v both write %v, set -o to write them into different files", first.Group, first.Name, c.Group, c.Name, path)
+		}
+		outputs[path] = c
+	}
+
+	if len(selected) == 0 {
+		return nil, errors.New("no command given")
+	}
+	return selected, nil
+}
+
+func isCommandGroup(commands []*command, s string) bool {
+	for _, c := range commands {
+		if c.Group == s {
+			return true
+		}
+	}
+	return false
+}
+
+func findCommand(commands []*command, group, name string) *command {
+	for _, c := range commands {
+		if c.Group == group && c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func printCommands(w io.Writer, commands []*command) {
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %v %v\n    \t%v\n", c.Group, c.Name, c.Usage)
+		c.Flags.VisitAll(func(f *flag.Flag) {
+			name, usage := flag.UnquoteUsage(f)
+			fmt.Fprintf(w, "      -%v %v\n        \t%v (default %q)\n", f.Name, name, strings.TrimSpace(usage), f.DefValue)
+		})
+	}
+}
+
+// ScriptOutput returns fileName if it is set and the default output path
+// of the template otherwise, so scripted triggers go straight into
+// common/scripted_triggers and other templates are named after themselves.
+func scriptOutput(templateName, fileName string) string {
+	if fileName != "" {
+		return fileName
+	}
+	if templateName == "state-triggers" {
+		return "common/scripted_triggers/hoi4geoparser_state_triggers.txt"
+	}
+	base := filepath.Base(filepath.FromSlash(templateName))
+	return strings.TrimSuffix(base, filepath.Ext(base)) + ".txt"
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseCommands(t *testing.T) {
+	tests := []struct {
+		args []string
+		want []string
+		err  string
+	}{
+		{
+			args: []string{"render", "manpower", "infrastructure"},
+			want: []string{"render manpower", "render infrastructure"},
+		},
+		{
+			args: []string{"render", "manpower", "-min", "10", "states", "export", "json", "script"},
+			want: []string{"render manpower", "render states", "export json", "export script"},
+		},
+		{
+			args: []string{"render", "heightmap-threshold", "-threshold", "255", "-spread", "0"},
+			want: []string{"render heightmap-threshold"},
+		},
+		{
+			args: []string{"export", "geodata", "script", "-template", "state-triggers", "script", "-template", "state-arrays"},
+			want: []string{"export geodata", "export script", "export script"},
+		},
+		{
+			args: []string{"export", "geodata", "script", "-o", "./hoi4geoparser_data.txt"},
+			err:  "export geodata and export script both write hoi4geoparser_data.txt, set -o to write them into different files",
+		},
+		{
+			args: []string{"export", "script", "-template", "state-arrays", "script", "-template", "state-arrays"},
+			err:  "export script and export script both write state-arrays.txt, set -o to write them into different files",
+		},
+		{
+			args: []string{"fix", "definitions", "continents"},
+			err:  "fix definitions and fix continents both write definition.csv, set -o to write them into different files",
+		},
+		{
+			args: []string{"manpower"},
+			err:  "unknown command: manpower",
+		},
+		{
+			args: []string{"render", "manpower", "definitions"},
+			err:  "unknown command: render definitions",
+		},
+		{
+			args: []string{"validate"},
+			err:  "validate: missing command name",
+		},
+		{
+			args: []string{},
+			err:  "no command given",
+		},
+		{
+			args: []string{"render", "manpower", "-min", "0"},
+			err:  "render manpower: -min must be positive, got 0",
+		},
+		{
+			args: []string{"render", "heightmap-threshold", "-threshold", "300"},
+			err:  "render heightmap-threshold: -threshold must be at most 255, got 300",
+		},
+		{
+			args: []string{"render", "heightmap-threshold", "-spread", "256"},
+			err:  "render heightmap-threshold: -spread must be at most 255, got 256",
+		},
+	}
+
+	for _, tt := range tests {
+		selected, err := parseCommands(tt.args)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%q: got error %v, want %q", tt.args, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.args, err)
+			continue
+		}
+		var got []string
+		for _, c := range selected {
+			got = append(got, c.Group+" "+c.Name)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.args, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%q: got %q, want %q", tt.args, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestParseCommandsOwnFlags(t *testing.T) {
+	selected, err := parseCommands([]string{"render", "small-provinces", "-threshold", "10", "small-provinces"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(selected) != 2 {
+		t.Fatalf("got %v commands, want 2", len(selected))
+	}
+	for i, want := range []string{"10", "32"} {
+		got := selected[i].Flags.Lookup("threshold").Value.String()
+		if got != want {
+			t.Errorf("command %v: got -threshold %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestScriptOutput(t *testing.T) {
+	tests := []struct {
+		template string
+		fileName string
+		want     string
+	}{
+		{"state-triggers", "", "common/scripted_triggers/hoi4geoparser_state_triggers.txt"},
+		{"geodata", "", "geodata.txt"},
+		{"province-arrays", "", "province-arrays.txt"},
+		{"templates/my_effects.tmpl", "", "my_effects.txt"},
+		{"state-triggers", "triggers.txt", "triggers.txt"},
+	}
+	for _, tt := range tests {
+		if got := scriptOutput(tt.template, tt.fileName); got != tt.want {
+			t.Errorf("%q, %q: got %q, want %q", tt.template, tt.fileName, got, tt.want)
+		}
+	}
+}
+
+func TestExportGeoDataAndScript(t *testing.T) {
+	setupValidationTest(t, nil)
+	oldLoaded := localisationLoaded
+	defer func() { localisationLoaded = oldLoaded }()
+	localisationLoaded = true
+
+	selected, err := parseCommands([]string{"export", "geodata", "script", "-template", "state-triggers"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, c := range selected {
+		err = c.Run()
+		if err != nil {
+			t.Fatalf("%v %v: %v", c.Group, c.Name, err)
+		}
+	}
+
+	for _, tt := range []struct{ path, want string }{
+		{"hoi4geoparser_data.txt", "on_actions = {"},
+		{"common/scripted_triggers/hoi4geoparser_state_triggers.txt", "is_impassable_state = {"},
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(outputPath, filepath.FromSlash(tt.path)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(b), tt.want) {
+			t.Errorf("%v: missing %q in:\n%s", tt.path, tt.want, b)
+		}
+	}
+}
+
+func TestParseFlagsLenient(t *testing.T) {
+	tests := []struct {
+		args    []string
+		lenient bool
+	}{
+		{[]string{"validate", "definitions", "pixels"}, true},
+		{[]string{"validate", "definitions", "render", "states"}, false},
+		{[]string{"export", "json"}, false},
+	}
+
+	for _, tt := range tests {
+		setupFlagsTest(t)
+		args := append([]string{"-game", t.TempDir(), "-out", t.TempDir()}, tt.args...)
+		_, err := parseFlags(args)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.args, err)
+			continue
+		}
+		if lenientParsing != tt.lenient {
+			t.Errorf("%q: got lenient parsing %v, want %v", tt.args, lenientParsing, tt.lenient)
+		}
+	}
+}
+
+func TestPrintCommands(t *testing.T) {
+	var sb strings.Builder
+	commands := newCommands()
+	printCommands(&sb, commands)
+	got := sb.String()
+
+	for _, c := range commands {
+		if !strings.Contains(got, "  "+c.Group+" "+c.Name+"\n    \t"+c.Usage+"\n") {
+			t.Errorf("%v %v: missing from the usage message", c.Group, c.Name)
+		}
+	}
+	want := "  render manpower\n    \tstate manpower map\n      -min int\n        \tmanpower value drawn with the lowest color (default \"1000\")\n"
+	if !strings.Contains(got, want) {
+		t.Errorf("missing %q in the usage message", want)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/image/draw"
+)
+
+// Country represents an in-game country with its map color.
+type Country struct {
+	Tag   string
+	Path  string
+	Color color.RGBA
+}
+
+func parseCountryFiles() error {
+	fmt.Printf("%s: Parsing country files...\n", time.Since(startTime))
+	countryTagFiles, err := globInputFiles(countryTagsPath, "common/country_tags", "*.txt")
+	if err != nil {
+		return err
+	}
+	for _, path := range countryTagFiles {
+		root, err := parseScriptFile(path)
+		if err != nil {
+			return err
+		}
+		for _, n := range root.Children {
+			// Skip "dynamic_tags = yes" and other settings.
+			if n.IsBlock || !n.Quoted {
+				continue
+			}
+			// A broken country file only affects the color of that country.
+			country, err := parseCountry(n.Key, n.Value)
+			if err != nil {
+				fmt.Printf("%s: %v, %v is drawn as unowned\n", time.Since(startTime), err, n.Key)
+				continue
+			}
+			countriesMap[country.Tag] = &country
+		}
+	}
+	return nil
+}
+
+// ParseCountry reads the color of a country from its file
+// which path is relative to the "common" folder.
+// With -countries set the file is taken from that folder instead.
+func parseCountry(tag, path string) (country Country, err error) {
+	country.Tag = tag
+	if countriesPath != "" {
+		country.Path = filepath.ToSlash(filepath.Join(filepath.FromSlash(countriesPath), filepath.Base(path)))
+	} else {
+		country.Path = resolveInputPath("", "common/"+path)
+	}
+
+	root, err := parseScriptFile(filepath.FromSlash(country.Path))
+	if err != nil {
+		return country, err
+	}
+	n := root.Find("color")
+	if n == nil {
+		return country, newFileError(root.Pos, "%v has no color", tag)
+	}
+	country.Color, err = parseColor(n)
+	return country, err
+}
+
+// ParseColor reads "color = { r g b }", "color = rgb { r g b }",
+// "color = hsv { h s v }" with hsv values between 0 and 1,
+// "color = hsv360 { h s v }" with h up to 360 and s and v up to 100
+// and "color = hex { 0xrrggbb }".
+func parseColor(n *scriptNode) (c color.RGBA, err error) {
+	if !n.IsBlock {
+		return c, newFileError(n.Pos, "%v: expected block", n.Key)
+	}
+	values := n.Values()
+	tag := strings.ToLower(n.Value)
+	if tag == "hex" {
+		if len(values) != 1 {
+			return c, newFileError(n.Pos, "%v: expected 1 value", n.Key)
+		}
+		hex := strings.TrimPrefix(strings.ToLower(values[0].Value), "0x")
+		v, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil || len(hex) != 6 {
+			return c, newFileError(values[0].Pos, "%v: invalid hex color %q, expected rrggbb", n.Key, values[0].Value)
+		}
+		return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}, nil
+	}
+
+	if len(values) != 3 {
+		return c, newFileError(n.Pos, "%v: expected 3 values", n.Key)
+	}
+	var v [3]float64
+	for i := range values {
+		v[i], err = values[i].Float()
+		if err != nil {
+			return c, newFileError(values[i].Pos, "%v: invalid color value %q", n.Key, values[i].Value)
+		}
+	}
+
+	switch tag {
+	case "", "rgb":
+		return color.RGBA{clampUint8(v[0]), clampUint8(v[1]), clampUint8(v[2]), 255}, nil
+	case "hsv":
+		return hsvToRGBA(v[0], v[1], v[2]), nil
+	case "hsv360":
+		return hsvToRGBA(v[0]/360, v[1]/100, v[2]/100), nil
+	}
+	return c, newFileError(n.Pos, "%v: unsupported color format %q", n.Key, n.Value)
+}
+
+func clampUint8(f float64) uint8 {
+	return uint8(math.Min(math.Max(math.Round(f), 0), 255))
+}
+
+func hsvToRGBA(h, s, v float64) color.RGBA {
+	h = math.Mod(h, 1) * 6
+	f := h - math.Floor(h)
+	p := v * (1 - s)
+	q := v * (1 - s*f)
+	t := v * (1 - s*(1-f))
+	var r, g, b float64
+	switch int(h) {
+	case 0:
+		r, g, b = v, t, p
+	case 1:
+		r, g, b = q, v, p
+	case 2:
+		r, g, b = p, v, t
+	case 3:
+		r, g, b = p, q, v
+	case 4:
+		r, g, b = t, p, v
+	default:
+		r, g, b = v, p, q
+	}
+	return color.RGBA{clampUint8(r * 255), clampUint8(g * 255), clampUint8(b * 255), 255}
+}
+
+func darkenColor(c color.RGBA, f float64) color.RGBA {
+	return color.RGBA{uint8(float64(c.R) * f), uint8(float64(c.G) * f), uint8(float64(c.B) * f), c.A}
+}
+
+func generatePoliticalMap(labels bool) error {
+	if len(countriesMap) == 0 {
+		err := parseCountryFiles()
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("%s: Generating political map...\n", time.Since(startTime))
+
+	// Create empty image and fill it with blue color (water).
+	img := image.NewRGBA(provincesImageSize)
+	draw.Draw(img, img.Bounds(), &image.Uniform{waterColor}, image.ZP, draw.Src)
+
+	// Draw state shapes with their owner colors
+	// and remember the owner of each pixel.
+	unownedColor := color.RGBA{200, 200, 200, 255}
+	owners := make(map[image.Point]string)
+	countryPixelCoords := make(map[string][]image.Point)
+	for _, s := range statesMap {
+		fillCol := unownedColor
+		if c, ok := countriesMap[s.Owner]; ok {
+			fillCol = c.Color
+		}
+		for _, p := range s.PixelCoords {
+			img.Set(p.X, p.Y, fillCol)
+			owners[p] = s.Owner
+		}
+		if s.Owner != "" {
+			countryPixelCoords[s.Owner] = append(countryPixelCoords[s.Owner], s.PixelCoords...)
+		}
+	}
+
+	// Draw lake province shapes over the land.
+	for _, prov := range provincesIDMap {
+		if prov.Type == "lake" {
+			for _, p := range prov.PixelCoords {
+				img.Set(p.X, p.Y, waterColor)
+				delete(owners, p)
+			}
+		}
+	}
+
+	// Draw state borders with a darker owner color.
+	for _, s := range statesMap {
+		borderCol := darkenColor(unownedColor, 0.8)
+		if c, ok := countriesMap[s.Owner]; ok {
+			borderCol = darkenColor(c.Color, 0.8)
+		}
+		for _, p := range s.PixelCoords {
+			_, exists := s.PixelCoordsMap[image.Point{p.X + 1, p.Y}]
+			if !exists && owners[image.Point{p.X + 1, p.Y}] == s.Owner {
+				img.Set(p.X+1, p.Y, borderCol)
+			}
+			_, exists = s.PixelCoordsMap[image.Point{p.X, p.Y + 1}]
+			if !exists && owners[image.Point{p.X, p.Y + 1}] == s.Owner {
+				img.Set(p.X, p.Y+1, borderCol)
+			}
+		}
+	}
+
+	// Draw country borders on both sides of the border, so they are two pixels wide.
+	countryBorderColor := color.RGBA{32, 32, 32, 255}
+	for p, owner := range owners {
+		for _, n := range []image.Point{{p.X + 1, p.Y}, {p.X - 1, p.Y}, {p.X, p.Y + 1}, {p.X, p.Y - 1}} {
+			o, exists := owners[n]
+			if exists && o != owner {
+				img.Set(p.X, p.Y, countryBorderColor)
+				break
+			}
+		}
+	}
+
+	if labels {
+		// Init font.
+		c, err := initFont(img)
+		if err != nil {
+			return err
+		}
+
+		// Draw country tags in the middle of all their states.
+		var tags []string
+		for tag := range countryPixelCoords {
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+		for _, tag := range tags {
+			center := findCenterPoint(countryPixelCoords[tag])
+			l := utf8.RuneCountInString(tag)
+			offset := (l*charWidth - strings.Count(tag, "1") + l - 1) / 2
+			err := addLabel(img, c, center.X-offset, center.Y+charHeight/2+1, 10.0, tag)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Save image as PNG.
+	out, err := os.Create(filepath.Join(outputPath, "political_map.png"))
+	if err != nil {
+		return err
+	}
+	err = png.Encode(out, img)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: Saved 'political_map.png'\n", time.Since(startTime))
+	return nil
+}
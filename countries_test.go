@@ -0,0 +1,52 @@
+package main
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		src  string
+		want color.RGBA
+		err  string
+	}{
+		{src: "color = { 10 20 30 }", want: color.RGBA{10, 20, 30, 255}},
+		{src: "color = rgb { 255 128.4 -5 }", want: color.RGBA{255, 128, 0, 255}},
+		{src: "color = RGB { 300 0 0 }", want: color.RGBA{255, 0, 0, 255}},
+		{src: "color = hsv { 0 1 1 }", want: color.RGBA{255, 0, 0, 255}},
+		{src: "color = hsv { 0.5 1 0.5 }", want: color.RGBA{0, 128, 128, 255}},
+		{src: "color = hsv { 1 0 1 }", want: color.RGBA{255, 255, 255, 255}},
+		{src: "color = hsv360 { 120 100 100 }", want: color.RGBA{0, 255, 0, 255}},
+		{src: "color = hsv360 { 240 50 100 }", want: color.RGBA{128, 128, 255, 255}},
+		{src: "color = hex { 0x4b2b07 }", want: color.RGBA{75, 43, 7, 255}},
+		{src: "color = hex { FFFFFF }", want: color.RGBA{255, 255, 255, 255}},
+		{src: "color = red", err: "f:1:1: color: expected block"},
+		{src: "color = { 1 2 }", err: "f:1:1: color: expected 3 values"},
+		{src: "color = rgb { 1 x 3 }", err: `f:1:17: color: invalid color value "x"`},
+		{src: "color = hex { 1 2 3 }", err: "f:1:1: color: expected 1 value"},
+		{src: "color = hex { 0xfff }", err: `f:1:15: color: invalid hex color "0xfff", expected rrggbb`},
+	}
+
+	for _, tt := range tests {
+		root, err := parseScript("f", []byte(tt.src))
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.src, err)
+			continue
+		}
+		c, err := parseColor(root.Find("color"))
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%q: got error %v, want %q", tt.src, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.src, err)
+			continue
+		}
+		if c != tt.want {
+			t.Errorf("%q: got %v, want %v", tt.src, c, tt.want)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SaveDefinitions writes definition.csv with the given provinces into the output folder.
+// Every province replaces the row it was read from, so the header, comments,
+// line endings and unchanged rows stay exactly as they are in the input file.
+// With showDiff set every changed row is printed before the file is written.
+func saveDefinitions(fileName string, provinces []Province, showDiff bool) error {
+	b, err := ioutil.ReadFile(filepath.FromSlash(definitionsPath))
+	if err != nil {
+		return err
+	}
+	lines := bytes.SplitAfter(b, []byte("\n"))
+
+	provincesByLine := make(map[int]*Province)
+	var newProvinces []*Province
+	for i := range provinces {
+		p := &provinces[i]
+		if p.Line > 0 && p.Line <= len(lines) {
+			provincesByLine[p.Line] = p
+		} else {
+			newProvinces = append(newProvinces, p)
+		}
+	}
+
+	var out bytes.Buffer
+	lineEnding := []byte("\r\n")
+	changed := 0
+	for i, l := range lines {
+		text, ending := splitLineEnding(l)
+		if i == 0 && len(ending) > 0 {
+			lineEnding = ending
+		}
+		p, ok := provincesByLine[i+1]
+		if !ok {
+			out.Write(l)
+			continue
+		}
+
+		prefix := []byte{}
+		if i == 0 && bytes.HasPrefix(text, utf8bom) {
+			prefix = utf8bom
+			text = text[len(utf8bom):]
+		}
+		old, err := parseDefinitionsProvince(filePos{}, string(text))
+		if err == nil && sameDefinition(old, *p) {
+			out.Write(l)
+			continue
+		}
+
+		row := formatDefinition(*p)
+		if showDiff {
+			fmt.Printf("%v:%v\n- %s\n+ %s\n", definitionsPath, i+1, text, row)
+		}
+		changed++
+		out.Write(prefix)
+		out.WriteString(row)
+		out.Write(ending)
+	}
+
+	// Provinces that were not read from the file are added at the end.
+	for _, p := range newProvinces {
+		if out.Len() > 0 && !bytes.HasSuffix(out.Bytes(), []byte("\n")) {
+			out.Write(lineEnding)
+		}
+		row := formatDefinition(*p)
+		if showDiff {
+			fmt.Printf("%v:%v\n+ %s\n", definitionsPath, len(lines)+1, row)
+		}
+		changed++
+		out.WriteString(row)
+		out.Write(lineEnding)
+	}
+	fmt.Printf("%s: %v definition rows changed\n", time.Since(startTime), changed)
+
+	err = ioutil.WriteFile(filepath.Join(outputPath, fileName), out.Bytes(), 0664)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: Saved '%v'\n", time.Since(startTime), fileName)
+	return nil
+}
+
+func splitLineEnding(l []byte) (text, ending []byte) {
+	text = bytes.TrimRight(l, "\r\n")
+	return text, l[len(text):]
+}
+
+func formatDefinition(p Province) string {
+	return fmt.Sprintf("%v;%v;%v;%v;%v;%v;%v;%v", p.ID, p.RGB.R, p.RGB.G, p.RGB.B, p.Type, p.IsCoastal, p.Terrain, p.Continent)
+}
+
+// SameDefinition reports whether two provinces have the same definition.csv fields.
+func sameDefinition(a, b Province) bool {
+	return a.ID == b.ID && a.RGB.R == b.RGB.R && a.RGB.G == b.RGB.G && a.RGB.B == b.RGB.B &&
+		a.Type == b.Type && a.IsCoastal == b.IsCoastal && a.Terrain == b.Terrain && a.Continent == b.Continent
+}
+
+// FixDefinitions writes definition.csv with coastal flags found in provinces.bmp,
+// terrain found in terrain.bmp and continents taken from an image,
+// printing every changed row first. The continent colors of the image
+// are given in continent order, see parseContinentColors.
+func fixDefinitions(fileName string, coastal, terrain bool, continentsPath, continentColors string) error {
+	fmt.Printf("%s: Fixing definition.csv...\n", time.Since(startTime))
+
+	var terrainImage *image.Paletted
+	if terrain {
+		err := loadTerrainFiles()
+		if err != nil {
+			return err
+		}
+		// Without terrain data the terrain is kept, like "validate terrain" does.
+		if len(terrainIndexMap) == 0 && len(terrainCategoriesMap) > 0 {
+			fmt.Printf("%s: No graphical_terrain found, province terrain is kept\n", time.Since(startTime))
+		} else if len(terrainIndexMap) > 0 {
+			terrainImage, err = loadTerrainImage()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	var continentsImage image.Image
+	var colors map[color.RGBA]int
+	if continentsPath != "" {
+		if continentColors == "" {
+			return errors.New("continent colors of the continents image are not set")
+		}
+		var err error
+		colors, err = parseContinentColors(continentColors)
+		if err != nil {
+			return err
+		}
+		continentsFile, err := os.Open(filepath.FromSlash(continentsPath))
+		if err != nil {
+			return err
+		}
+		defer continentsFile.Close()
+		continentsImage, _, err = image.Decode(continentsFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	var fixed []Province
+	for _, id := range sortedKeySliceFromProvinceMap(provincesIDMap) {
+		p := *provincesIDMap[id]
+		// The first line is a placeholder for the map edges.
+		if p.ID != 0 {
+			if coastal {
+				p.IsCoastal = isCoastalProvince(&p)
+			}
+			if terrainImage != nil && p.Type == "land" {
+				if t := dominantTerrain(&p, terrainImage); t != "" {
+					p.Terrain = t
+				}
+			}
+			if continentsImage != nil {
+				c, err := continentFromImage(&p, continentsImage, colors)
+				if err != nil {
+					// The continent is kept and the other provinces are still fixed.
+					issue := validationIssue{Check: "definitions", IDs: []int{p.ID}, Message: err.Error()}
+					addValidationIssue(issue)
+					fmt.Printf("%s: %v, the continent is kept\n", time.Since(startTime), issue)
+				} else {
+					p.Continent = c
+				}
+			}
+		}
+		fixed = append(fixed, p)
+	}
+	return saveDefinitions(fileName, fixed, true)
+}
+
+// ParseContinentColors reads a comma separated list of hex colors like "4b2b07,ffffff",
+// the nth color marks continent n in the continents image.
+func parseContinentColors(s string) (map[color.RGBA]int, error) {
+	colors := make(map[color.RGBA]int)
+	for i, hex := range strings.Split(s, ",") {
+		hex = strings.TrimPrefix(strings.TrimSpace(hex), "#")
+		v, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil || len(hex) != 6 {
+			return nil, fmt.Errorf("invalid continent color %q, expected rrggbb", hex)
+		}
+		c := color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}
+		if n, ok := colors[c]; ok {
+			return nil, fmt.Errorf("continent color %v is used by continents %v and %v", hex, n, i+1)
+		}
+		colors[c] = i + 1
+	}
+	return colors, nil
+}
+
+// ContinentFromImage returns the continent of the province color in the continents image.
+// Colors missing in continentColors mean no continent.
+func continentFromImage(p *Province, continentsImage image.Image, continentColors map[color.RGBA]int) (int, error) {
+	continent := 0
+	if p.Terrain == "ocean" {
+		return continent, nil
+	}
+
+	var col1 color.RGBA
+	for _, px := range p.PixelCoords {
+		col2 := continentsImage.At(px.X, px.Y)
+		r1, g1, b1, a1 := col1.RGBA()
+		r2, g2, b2, _ := col2.RGBA()
+
+		if a1 != 0 && (r1 != r2 || g1 != g2 || b1 != b2) {
+			return 0, fmt.Errorf("different continent colors at (%v,%v)", px.X, px.Y)
+		}
+
+		r, g, b, a := col2.RGBA()
+		col1 = color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)}
+
+		if c, ok := continentColors[col1]; ok {
+			continent = c
+		}
+	}
+	return continent, nil
+}
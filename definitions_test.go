@@ -0,0 +1,167 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestSaveDefinitions(t *testing.T) {
+	// The input keeps a BOM, mixed line endings and a comment line.
+	const src = "\xEF\xBB\xBF0;0;0;0;land;false;unknown;0\r\n# comment\n1;10;20;30;land;false;plains;1\n2;40;50;60;sea;true;ocean;0\r\n"
+	province := func(line, id int, r, g, b uint8, typ string, coastal bool, terrain string, continent int) Province {
+		return Province{ID: id, Line: line, RGB: color.RGBA{r, g, b, 255}, Type: typ, IsCoastal: coastal, Terrain: terrain, Continent: continent}
+	}
+	unchanged := []Province{
+		province(1, 0, 0, 0, 0, "land", false, "unknown", 0),
+		province(3, 1, 10, 20, 30, "land", false, "plains", 1),
+		province(4, 2, 40, 50, 60, "sea", true, "ocean", 0),
+	}
+
+	tests := []struct {
+		name      string
+		src       string
+		provinces []Province
+		want      string
+	}{
+		{
+			name:      "unchanged",
+			src:       src,
+			provinces: unchanged,
+			want:      src,
+		},
+		{
+			name: "changed rows",
+			src:  src,
+			provinces: []Province{
+				province(1, 0, 0, 0, 0, "land", false, "unknown", 1),
+				province(3, 1, 10, 20, 30, "land", true, "forest", 1),
+				unchanged[2],
+			},
+			want: "\xEF\xBB\xBF0;0;0;0;land;false;unknown;1\r\n# comment\n1;10;20;30;land;true;forest;1\n2;40;50;60;sea;true;ocean;0\r\n",
+		},
+		{
+			name:      "appended province",
+			src:       src,
+			provinces: append(unchanged[:3:3], province(0, 3, 70, 80, 90, "lake", false, "lakes", 0)),
+			want:      src + "3;70;80;90;lake;false;lakes;0\r\n",
+		},
+		{
+			name:      "appended province without a final line ending",
+			src:       "0;0;0;0;land;false;unknown;0\n1;10;20;30;land;false;plains;1",
+			provinces: []Province{unchanged[0], province(2, 1, 10, 20, 30, "land", false, "plains", 1), province(0, 3, 70, 80, 90, "lake", false, "lakes", 0)},
+			want:      "0;0;0;0;land;false;unknown;0\n1;10;20;30;land;false;plains;1\n3;70;80;90;lake;false;lakes;0\n",
+		},
+	}
+
+	oldDefinitions, oldOutput := definitionsPath, outputPath
+	defer func() { definitionsPath, outputPath = oldDefinitions, oldOutput }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			definitionsPath = filepath.Join(t.TempDir(), "definition.csv")
+			outputPath = t.TempDir()
+			err := ioutil.WriteFile(definitionsPath, []byte(tt.src), 0664)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = saveDefinitions("definition.csv", tt.provinces, false)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			b, err := ioutil.ReadFile(filepath.Join(outputPath, "definition.csv"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("got definition.csv:\n%q\nwant:\n%q", b, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDefinitionsDuplicates(t *testing.T) {
+	oldDefinitions, oldIDs, oldRGBs := definitionsPath, provincesIDMap, provincesRGBMap
+	oldIssues, oldLenient := validationIssues, lenientParsing
+	defer func() {
+		definitionsPath, provincesIDMap, provincesRGBMap = oldDefinitions, oldIDs, oldRGBs
+		validationIssues, lenientParsing = oldIssues, oldLenient
+	}()
+
+	const src = "0;0;0;0;land;false;unknown;0\n1;10;20;30;land;false;plains;1\n1;40;50;60;land;false;plains;1\n2;10;20;30;sea;false;ocean;0\n"
+	definitionsPath = filepath.ToSlash(filepath.Join(t.TempDir(), "definition.csv"))
+	err := ioutil.WriteFile(definitionsPath, []byte(src), 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Skipped duplicates are recorded for the report whether or not the run is lenient.
+	for _, lenient := range []bool{false, true} {
+		provincesIDMap = make(map[int]*Province)
+		provincesRGBMap = make(map[color.Color]*Province)
+		validationIssues = nil
+		lenientParsing = lenient
+
+		err = parseDefinitions()
+		if err != nil {
+			t.Fatalf("lenient %v: unexpected error: %v", lenient, err)
+		}
+		if len(provincesIDMap) != 2 || len(provincesRGBMap) != 2 || provincesIDMap[1].Line != 2 {
+			t.Errorf("lenient %v: got %v IDs and %v colors, want the first definitions of provinces 0 and 1", lenient, len(provincesIDMap), len(provincesRGBMap))
+		}
+		want := []string{
+			definitionsPath + ":3: duplicate province ID 1, first defined on line 2, the entry is skipped [provinces 1]",
+			definitionsPath + ":4: duplicate color 10;20;30 of province 2, first used by province 1, the entry is skipped [provinces 1, 2]",
+		}
+		compareIssues(t, "lenient "+strconv.FormatBool(lenient), issueMessages(), want)
+	}
+}
+
+func TestFixDefinitionsContinents(t *testing.T) {
+	setupValidationTest(t, map[int]string{3: "sea"}, "1223")
+	dir := t.TempDir()
+	definitionsPath = filepath.Join(dir, "definition.csv")
+	err := ioutil.WriteFile(definitionsPath, []byte("1;0;0;0;land;false;plains;1\n2;0;0;0;land;false;plains;1\n3;0;0;0;sea;false;ocean;0\n"), 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Province 1 is on continent 2, province 2 has pixels of both continents.
+	img := image.NewRGBA(image.Rect(0, 0, 4, 1))
+	for x, c := range []color.RGBA{{255, 255, 255, 255}, {75, 43, 7, 255}, {255, 255, 255, 255}, {0, 0, 255, 255}} {
+		img.Set(x, 0, c)
+	}
+	continentsPath := filepath.Join(dir, "continents.png")
+	f, err := os.Create(continentsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = png.Encode(f, img)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = fixDefinitions("definition.csv", false, false, continentsPath, "4b2b07,ffffff")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(outputPath, "definition.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "1;0;0;0;land;false;plains;2\n2;0;0;0;land;false;plains;1\n3;0;0;0;sea;false;ocean;0\n"
+	if string(b) != want {
+		t.Errorf("got definition.csv:\n%q\nwant:\n%q", b, want)
+	}
+	wantIssue := "different continent colors at (2,0) [provinces 2]"
+	if got := issueMessages(); len(got) != 1 || got[0] != wantIssue {
+		t.Errorf("got issues %q, want %q", got, wantIssue)
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+type jsonGeoData struct {
+	Width            int                   `json:"width"`
+	Height           int                   `json:"height"`
+	KmPerPixel       float64               `json:"km_per_pixel"`
+	Date             string                `json:"date"`
+	Provinces        []jsonProvince        `json:"provinces"`
+	States           []jsonState           `json:"states"`
+	StrategicRegions []jsonStrategicRegion `json:"strategic_regions"`
+	Adjacencies      []jsonAdjacency       `json:"adjacencies"`
+	AdjacencyRules   []jsonAdjacencyRule   `json:"adjacency_rules"`
+}
+
+type jsonPoint struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type jsonRect struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+type jsonProvince struct {
+	ID              int            `json:"id"`
+	RGB             [3]uint8       `json:"rgb"`
+	Type            string         `json:"type"`
+	IsCoastal       bool           `json:"is_coastal"`
+	Terrain         string         `json:"terrain"`
+	Continent       int            `json:"continent"`
+	State           int            `json:"state,omitempty"`
+	StrategicRegion int            `json:"strategic_region,omitempty"`
+	Center          jsonPoint      `json:"center"`
+	Area            int            `json:"area"`
+	BoundingBox     jsonRect       `json:"bounding_box"`
+	AdjacentTo      []int          `json:"adjacent_to"`
+	ConnectedTo     []int          `json:"connected_to"`
+	ImpassableTo    []int          `json:"impassable_to"`
+	VictoryPoints   int            `json:"victory_points,omitempty"`
+	Buildings       map[string]int `json:"buildings,omitempty"`
+}
+
+type jsonState struct {
+	ID             int                `json:"id"`
+	Name           string             `json:"name"`
+	LocalisedName  string             `json:"localised_name"`
+	Manpower       int                `json:"manpower"`
+	Infrastructure int                `json:"infrastructure"`
+	Category       string             `json:"category"`
+	BuildingSlots  int                `json:"building_slots"`
+	Resources      map[string]float64 `json:"resources"`
+	Owner          string             `json:"owner"`
+	Controller     string             `json:"controller"`
+	Cores          []string           `json:"cores"`
+	Claims         []string           `json:"claims"`
+	Buildings      map[string]int     `json:"buildings"`
+	VictoryPoints  map[int]int        `json:"victory_points"`
+	IsCoastal      bool               `json:"is_coastal"`
+	IsImpassable   bool               `json:"is_impassable"`
+	Continent      int                `json:"continent"`
+	Center         jsonPoint          `json:"center"`
+	Area           int                `json:"area"`
+	BoundingBox    jsonRect           `json:"bounding_box"`
+	Provinces      []int              `json:"provinces"`
+	AdjacentTo     []int              `json:"adjacent_to"`
+	ConnectedTo    []int              `json:"connected_to"`
+	ImpassableTo   []int              `json:"impassable_to"`
+	DistanceTo     map[int]int        `json:"distance_to"`
+}
+
+type jsonStrategicRegion struct {
+	ID            int       `json:"id"`
+	Name          string    `json:"name"`
+	LocalisedName string    `json:"localised_name"`
+	Center        jsonPoint `json:"center"`
+	Area          int       `json:"area"`
+	BoundingBox   jsonRect  `json:"bounding_box"`
+	IsSea         bool      `json:"is_sea"`
+	Provinces     []int     `json:"provinces"`
+	AdjacentTo    []int     `json:"adjacent_to"`
+	ConnectedTo   []int     `json:"connected_to"`
+}
+
+type jsonAdjacency struct {
+	From    int        `json:"from"`
+	To      int        `json:"to"`
+	Type    string     `json:"type"`
+	Through int        `json:"through,omitempty"`
+	Start   *jsonPoint `json:"start,omitempty"`
+	Stop    *jsonPoint `json:"stop,omitempty"`
+	Rule    string     `json:"rule,omitempty"`
+	Comment string     `json:"comment,omitempty"`
+}
+
+type jsonAdjacencyRule struct {
+	Name              string              `json:"name"`
+	Contested         jsonAdjacencyAccess `json:"contested"`
+	Enemy             jsonAdjacencyAccess `json:"enemy"`
+	Friend            jsonAdjacencyAccess `json:"friend"`
+	Neutral           jsonAdjacencyAccess `json:"neutral"`
+	RequiredProvinces []int               `json:"required_provinces"`
+	Icon              int                 `json:"icon,omitempty"`
+}
+
+type jsonAdjacencyAccess struct {
+	Army      bool `json:"army"`
+	Navy      bool `json:"navy"`
+	Submarine bool `json:"submarine"`
+	Trade     bool `json:"trade"`
+}
+
+// SaveGeoDataJSON writes every parsed province, state and strategic region into a JSON file.
+func saveGeoDataJSON(fileName string, indent bool) error {
+	err := localiseNames()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: Writing the JSON file...\n", time.Since(startTime))
+	data := jsonGeoData{
+		Width:            provincesImageSize.Dx(),
+		Height:           provincesImageSize.Dy(),
+		KmPerPixel:       mapScalePixelToKm,
+		Date:             startDate.String(),
+		Provinces:        []jsonProvince{},
+		States:           []jsonState{},
+		StrategicRegions: []jsonStrategicRegion{},
+		Adjacencies:      []jsonAdjacency{},
+		AdjacencyRules:   []jsonAdjacencyRule{},
+	}
+
+	for _, id := range sortedKeySliceFromProvinceMap(provincesIDMap) {
+		p := provincesIDMap[id]
+		jp := jsonProvince{
+			ID:            p.ID,
+			RGB:           [3]uint8{p.RGB.R, p.RGB.G, p.RGB.B},
+			Type:          p.Type,
+			IsCoastal:     p.IsCoastal,
+			Terrain:       p.Terrain,
+			Continent:     p.Continent,
+			Center:        jsonPoint{p.CenterPoint.X, p.CenterPoint.Y},
+			Area:          len(p.PixelCoords),
+			BoundingBox:   newJSONRect(boundingBox(p.PixelCoords)),
+			AdjacentTo:    sortedKeySliceFromProvinceMap(p.AdjacentTo),
+			ConnectedTo:   sortedKeySliceFromProvinceMap(p.ConnectedTo),
+			ImpassableTo:  sortedKeySliceFromProvinceMap(p.ImpassableTo),
+			VictoryPoints: p.VictoryPoints,
+			Buildings:     p.Buildings,
+		}
+		if p.State != nil {
+			jp.State = p.State.ID
+		}
+		if p.StrategicRegion != nil {
+			jp.StrategicRegion = p.StrategicRegion.ID
+		}
+		data.Provinces = append(data.Provinces, jp)
+	}
+
+	for _, id := range sortedKeySliceFromStateMap(statesMap) {
+		s := statesMap[id]
+		data.States = append(data.States, jsonState{
+			ID:             s.ID,
+			Name:           s.Name,
+			LocalisedName:  s.LocalisedName,
+			Manpower:       s.Manpower,
+			Infrastructure: s.Infrastructure,
+			Category:       s.Category,
+			BuildingSlots:  s.BuildingSlots,
+			Resources:      s.Resources,
+			Owner:          s.Owner,
+			Controller:     s.Controller,
+			Cores:          nonNilStrings(s.Cores),
+			Claims:         nonNilStrings(s.Claims),
+			Buildings:      s.Buildings,
+			VictoryPoints:  s.VictoryPoints,
+			IsCoastal:      s.IsCoastal,
+			IsImpassable:   s.IsImpassable,
+			Continent:      s.Continent,
+			Center:         jsonPoint{s.CenterPoint.X, s.CenterPoint.Y},
+			Area:           len(s.PixelCoords),
+			BoundingBox:    newJSONRect(boundingBox(s.PixelCoords)),
+			Provinces:      sortedKeySliceFromProvinceMap(s.Provinces),
+			AdjacentTo:     sortedKeySliceFromStateMap(s.AdjacentTo),
+			ConnectedTo:    sortedKeySliceFromStateMap(s.ConnectedTo),
+			ImpassableTo:   sortedKeySliceFromStateMap(s.ImpassableTo),
+			DistanceTo:     s.DistanceTo,
+		})
+	}
+
+	for _, id := range sortedKeySliceFromStrategicRegionMap(strategicRegionMap) {
+		r := strategicRegionMap[id]
+		data.StrategicRegions = append(data.StrategicRegions, jsonStrategicRegion{
+			ID:            r.ID,
+			Name:          r.Name,
+			LocalisedName: r.LocalisedName,
+			Center:        jsonPoint{r.CenterPoint.X, r.CenterPoint.Y},
+			Area:          len(r.PixelCoords),
+			BoundingBox:   newJSONRect(boundingBox(r.PixelCoords)),
+			IsSea:         r.IsSea,
+			Provinces:     sortedKeySliceFromProvinceMap(r.Provinces),
+			AdjacentTo:    sortedKeySliceFromStrategicRegionMap(r.AdjacentTo),
+			ConnectedTo:   sortedKeySliceFromStrategicRegionMap(r.ConnectedTo),
+		})
+	}
+
+	for _, a := range adjacencies {
+		ja := jsonAdjacency{Type: a.Type, Rule: a.RuleName, Comment: a.Comment}
+		if a.From != nil {
+			ja.From = a.From.ID
+		}
+		if a.To != nil {
+			ja.To = a.To.ID
+		}
+		if a.Through != nil {
+			ja.Through = a.Through.ID
+		}
+		if a.HasStart {
+			ja.Start = &jsonPoint{a.Start.X, a.Start.Y}
+		}
+		if a.HasStop {
+			ja.Stop = &jsonPoint{a.Stop.X, a.Stop.Y}
+		}
+		data.Adjacencies = append(data.Adjacencies, ja)
+	}
+
+	var ruleNames []string
+	for name := range adjacencyRulesMap {
+		ruleNames = append(ruleNames, name)
+	}
+	sort.Strings(ruleNames)
+	for _, name := range ruleNames {
+		r := adjacencyRulesMap[name]
+		data.AdjacencyRules = append(data.AdjacencyRules, jsonAdjacencyRule{
+			Name:              r.Name,
+			Contested:         jsonAdjacencyAccess(r.Contested),
+			Enemy:             jsonAdjacencyAccess(r.Enemy),
+			Friend:            jsonAdjacencyAccess(r.Friend),
+			Neutral:           jsonAdjacencyAccess(r.Neutral),
+			RequiredProvinces: nonNilInts(r.RequiredProvinces),
+			Icon:              r.Icon,
+		})
+	}
+
+	f, err := os.Create(filepath.Join(outputPath, fileName))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	if indent {
+		enc.SetIndent("", "\t")
+	}
+	err = enc.Encode(data)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: Saved '%v'\n", time.Since(startTime), fileName)
+	return nil
+}
+
+func newJSONRect(r image.Rectangle) jsonRect {
+	return jsonRect{r.Min.X, r.Min.Y, r.Dx(), r.Dy()}
+}
+
+// BoundingBox returns the smallest rectangle containing all coordinates.
+func boundingBox(coords []image.Point) (r image.Rectangle) {
+	for i, c := range coords {
+		if i == 0 {
+			r = image.Rectangle{c, c.Add(image.Point{1, 1})}
+			continue
+		}
+		r = r.Union(image.Rectangle{c, c.Add(image.Point{1, 1})})
+	}
+	return r
+}
+
+func nonNilInts(s []int) []int {
+	if s == nil {
+		return []int{}
+	}
+	return s
+}
+
+func nonNilStrings(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSaveGeoDataJSON(t *testing.T) {
+	// Land provinces 1 and 2 in state 1 and region 1, sea province 3 in region 2.
+	setupValidationTest(t, map[int]string{3: "sea"}, "112", "123")
+	oldLoaded, oldAdjacencies, oldRules := localisationLoaded, adjacencies, adjacencyRulesMap
+	defer func() { localisationLoaded, adjacencies, adjacencyRulesMap = oldLoaded, oldAdjacencies, oldRules }()
+	localisationLoaded = true
+
+	p := provincesIDMap
+	p[1].RGB.R, p[1].RGB.G, p[1].RGB.B = 10, 20, 30
+	p[1].IsCoastal = true
+	p[1].CenterPoint = image.Point{0, 0}
+	p[1].ConnectedTo[2] = p[2]
+	p[1].VictoryPoints = 5
+
+	s := &State{
+		ID:            1,
+		Name:          "STATE_1",
+		LocalisedName: "North",
+		Manpower:      1000,
+		Category:      "town",
+		BuildingSlots: 4,
+		Resources:     map[string]float64{"oil": 2},
+		Owner:         "AAA",
+		Controller:    "AAA",
+		Buildings:     map[string]int{},
+		VictoryPoints: map[int]int{1: 5},
+		DistanceTo:    map[int]int{},
+		Provinces:     map[int]*Province{1: p[1], 2: p[2]},
+		PixelCoords:   append(append([]image.Point{}, p[1].PixelCoords...), p[2].PixelCoords...),
+	}
+	p[1].State, p[2].State = s, s
+	statesMap[1] = s
+
+	r1 := &StrategicRegion{ID: 1, Name: "REGION_1", Provinces: map[int]*Province{1: p[1], 2: p[2]}}
+	r2 := &StrategicRegion{ID: 2, Name: "REGION_2", IsSea: true, Provinces: map[int]*Province{3: p[3]}}
+	r1.AdjacentTo = map[int]*StrategicRegion{2: r2}
+	r2.AdjacentTo = map[int]*StrategicRegion{1: r1}
+	p[3].StrategicRegion = r2
+	strategicRegionMap[1], strategicRegionMap[2] = r1, r2
+
+	rule := &AdjacencyRule{Name: "STRAIT", Friend: AdjacencyAccess{Army: true, Navy: true}}
+	adjacencyRulesMap = map[string]*AdjacencyRule{"STRAIT": rule}
+	adjacencies = []*Adjacency{
+		{From: p[1], To: p[2], Type: "sea", Through: p[3], Start: image.Point{1, 0}, HasStart: true, RuleName: "STRAIT", Rule: rule},
+	}
+
+	err := saveGeoDataJSON("geo.json", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(outputPath, "geo.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data jsonGeoData
+	err = json.Unmarshal(b, &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data.Width != 3 || data.Height != 2 || data.Date != "1936.1.1" {
+		t.Errorf("got size %vx%v, date %v", data.Width, data.Height, data.Date)
+	}
+
+	if len(data.Provinces) != 3 {
+		t.Fatalf("got %v provinces, want 3", len(data.Provinces))
+	}
+	jp := data.Provinces[0]
+	if jp.ID != 1 || jp.RGB != [3]uint8{10, 20, 30} || jp.Type != "land" || !jp.IsCoastal || jp.State != 1 || jp.StrategicRegion != 0 || jp.VictoryPoints != 5 {
+		t.Errorf("got province %+v", jp)
+	}
+	if jp.Area != 3 || jp.BoundingBox != (jsonRect{0, 0, 2, 2}) {
+		t.Errorf("province 1: got area %v, bounding box %+v", jp.Area, jp.BoundingBox)
+	}
+	if joinInts(jp.AdjacentTo) != "2" || joinInts(jp.ConnectedTo) != "2" {
+		t.Errorf("province 1: got adjacent to %v, connected to %v", jp.AdjacentTo, jp.ConnectedTo)
+	}
+	if data.Provinces[2].StrategicRegion != 2 || data.Provinces[2].State != 0 {
+		t.Errorf("got province %+v", data.Provinces[2])
+	}
+
+	if len(data.States) != 1 {
+		t.Fatalf("got %v states, want 1", len(data.States))
+	}
+	js := data.States[0]
+	if js.Name != "STATE_1" || js.LocalisedName != "North" || js.Category != "town" || js.BuildingSlots != 4 || js.Resources["oil"] != 2 || js.Owner != "AAA" {
+		t.Errorf("got state %+v", js)
+	}
+	if joinInts(js.Provinces) != "1, 2" || js.Area != 5 || js.VictoryPoints[1] != 5 {
+		t.Errorf("state 1: got provinces %v, area %v, victory points %v", js.Provinces, js.Area, js.VictoryPoints)
+	}
+
+	if len(data.StrategicRegions) != 2 {
+		t.Fatalf("got %v strategic regions, want 2", len(data.StrategicRegions))
+	}
+	if jr := data.StrategicRegions[1]; jr.ID != 2 || !jr.IsSea || joinInts(jr.Provinces) != "3" || joinInts(jr.AdjacentTo) != "1" {
+		t.Errorf("got strategic region %+v", jr)
+	}
+
+	if len(data.Adjacencies) != 1 {
+		t.Fatalf("got %v adjacencies, want 1", len(data.Adjacencies))
+	}
+	ja := data.Adjacencies[0]
+	if ja.From != 1 || ja.To != 2 || ja.Type != "sea" || ja.Through != 3 || ja.Rule != "STRAIT" || ja.Start == nil || *ja.Start != (jsonPoint{1, 0}) || ja.Stop != nil {
+		t.Errorf("got adjacency %+v", ja)
+	}
+	if len(data.AdjacencyRules) != 1 || !data.AdjacencyRules[0].Friend.Navy || data.AdjacencyRules[0].Enemy.Army {
+		t.Errorf("got adjacency rules %+v", data.AdjacencyRules)
+	}
+
+	// Empty lists are written as arrays, not null.
+	for _, want := range []string{`"cores":[]`, `"claims":[]`, `"required_provinces":[]`, `"impassable_to":[]`} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("missing %s in the JSON output", want)
+		}
+	}
+	if strings.Contains(string(b), "null") {
+		t.Errorf("got null in the JSON output")
+	}
+}
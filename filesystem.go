@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ModFileSystem merges the base game folder with mod folders in load order.
+// Files in later layers override files with the same path in earlier ones.
+type modFileSystem struct {
+	Layers []*modLayer
+}
+
+// ModLayer represents the base game folder or a single mod folder.
+type modLayer struct {
+	Name         string
+	Path         string
+	ReplacePaths []string // Slash separated folders relative to Path.
+}
+
+// StringList is a flag.Value that collects every occurrence of a repeated flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// NewModFileSystem creates a file system from the base game folder
+// and a list of mod folders or .mod descriptor files in load order.
+func newModFileSystem(gamePath string, modPaths []string) (*modFileSystem, error) {
+	fs := &modFileSystem{}
+	if gamePath != "" {
+		fs.Layers = append(fs.Layers, &modLayer{Name: "base game", Path: filepath.FromSlash(gamePath)})
+	}
+	for _, p := range modPaths {
+		l, err := newModLayer(filepath.FromSlash(p))
+		if err != nil {
+			return nil, err
+		}
+		fs.Layers = append(fs.Layers, l)
+	}
+	return fs, nil
+}
+
+// NewModLayer creates a layer from a mod folder or a .mod descriptor file.
+// A mod folder uses the replace_path entries of its descriptor.mod if it has one.
+func newModLayer(p string) (*modLayer, error) {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+
+	descriptorPath := p
+	if fi.IsDir() {
+		descriptorPath = filepath.Join(p, "descriptor.mod")
+		_, err = os.Stat(descriptorPath)
+		if os.IsNotExist(err) {
+			return &modLayer{Name: filepath.Base(p), Path: p}, nil
+		}
+	}
+
+	l, err := parseModDescriptor(descriptorPath)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		l.Path = p
+	}
+	if l.Name == "" {
+		l.Name = filepath.Base(p)
+	}
+	return l, nil
+}
+
+// ParseModDescriptor reads name, path and replace_path entries from a .mod file.
+// A relative path is resolved against the user game folder
+// that holds the "mod" folder with the descriptor.
+func parseModDescriptor(p string) (*modLayer, error) {
+	root, err := parseScriptFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &modLayer{}
+	for _, n := range root.Children {
+		switch n.Key {
+		case "name":
+			l.Name = n.Value
+		case "path":
+			l.Path = filepath.FromSlash(n.Value)
+		case "archive":
+			return nil, newFileError(n.Pos, "archived mods are not supported")
+		case "replace_path":
+			l.ReplacePaths = append(l.ReplacePaths, cleanRelPath(n.Value))
+		}
+	}
+
+	if l.Path != "" && !filepath.IsAbs(l.Path) {
+		l.Path = filepath.Join(filepath.Dir(filepath.Dir(p)), l.Path)
+	}
+	if l.Path == "" && filepath.Base(p) != "descriptor.mod" {
+		return nil, errors.New("\"" + p + "\": descriptor has no path")
+	}
+	return l, nil
+}
+
+func cleanRelPath(p string) string {
+	return strings.Trim(path.Clean(filepath.ToSlash(p)), "/")
+}
+
+// Replaces reports whether the layer hides files of earlier layers in dir.
+func (l *modLayer) Replaces(dir string) bool {
+	for _, rp := range l.ReplacePaths {
+		if dir == rp || strings.HasPrefix(dir, rp+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// HideReplaced removes the files of earlier layers that are in a folder
+// the layer replaces. Files are keyed by their slash separated path relative to dir,
+// so a replaced subfolder of dir only hides the files inside of it.
+func (l *modLayer) hideReplaced(dir string, files map[string]string) {
+	for rel := range files {
+		if l.Replaces(path.Dir(path.Join(dir, rel))) {
+			delete(files, rel)
+		}
+	}
+}
+
+// Path returns the effective location of the file at rel,
+// searching the layers from the last one loaded.
+func (fs *modFileSystem) Path(rel string) (string, error) {
+	rel = cleanRelPath(rel)
+	dir := path.Dir(rel)
+	for i := len(fs.Layers) - 1; i >= 0; i-- {
+		l := fs.Layers[i]
+		p := filepath.Join(l.Path, filepath.FromSlash(rel))
+		_, err := os.Stat(p)
+		if err == nil {
+			return p, nil
+		}
+		if l.Replaces(dir) {
+			break
+		}
+	}
+	return "", fmt.Errorf("%v: file not found in %v", rel, fs)
+}
+
+// Glob returns the effective set of files in the folder dir matching pattern,
+// sorted by file name the way the game loads them.
+func (fs *modFileSystem) Glob(dir, pattern string) ([]string, error) {
+	dir = cleanRelPath(dir)
+	files := make(map[string]string)
+	for _, l := range fs.Layers {
+		l.hideReplaced(dir, files)
+		matches, err := filepath.Glob(filepath.Join(l.Path, filepath.FromSlash(dir), pattern))
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			files[filepath.Base(m)] = m
+		}
+	}
+
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	paths := make([]string, 0, len(names))
+	for _, name := range names {
+		paths = append(paths, files[name])
+	}
+	return paths, nil
+}
+
+func (fs *modFileSystem) String() string {
+	var names []string
+	for _, l := range fs.Layers {
+		names = append(names, "\""+l.Name+"\"")
+	}
+	return strings.Join(names, ", ")
+}
+
+// GlobAll returns the effective set of files matching pattern in dir
+// and all of its subfolders, sorted by their path relative to dir.
+func (fs *modFileSystem) GlobAll(dir, pattern string) ([]string, error) {
+	dir = cleanRelPath(dir)
+	files := make(map[string]string)
+	for _, l := range fs.Layers {
+		l.hideReplaced(dir, files)
+		root := filepath.Join(l.Path, filepath.FromSlash(dir))
+		err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && p == root {
+					return filepath.SkipDir
+				}
+				return err
+			}
+			if fi.IsDir() {
+				return nil
+			}
+			ok, err := filepath.Match(pattern, fi.Name())
+			if err != nil || !ok {
+				return err
+			}
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+			files[filepath.ToSlash(rel)] = p
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var rels []string
+	for rel := range files {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+
+	paths := make([]string, 0, len(rels))
+	for _, rel := range rels {
+		paths = append(paths, files[rel])
+	}
+	return paths, nil
+}
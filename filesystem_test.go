@@ -0,0 +1,304 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// WriteTestFiles creates empty files at the slash separated paths inside dir.
+func writeTestFiles(t *testing.T, dir string, files ...string) {
+	for _, f := range files {
+		p := filepath.Join(dir, filepath.FromSlash(f))
+		err := os.MkdirAll(filepath.Dir(p), 0775)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(p, nil, 0664)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// RelPaths returns the paths as "<layer folder>/<path>" relative to root.
+func relPaths(t *testing.T, root string, paths []string) string {
+	var rels []string
+	for _, p := range paths {
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rels = append(rels, filepath.ToSlash(rel))
+	}
+	return strings.Join(rels, " ")
+}
+
+func TestModFileSystem(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, filepath.Join(root, "game"),
+		"map/definition.csv",
+		"map/adjacencies.csv",
+		"history/states/1.txt",
+		"history/states/2.txt",
+		"localisation/english/a_l_english.yml",
+		"localisation/english/b_l_english.yml",
+		"localisation/c_l_english.yml",
+	)
+	writeTestFiles(t, filepath.Join(root, "mod1"),
+		"map/definition.csv",
+		"history/states/3.txt",
+		"localisation/english/d_l_english.yml",
+	)
+	writeTestFiles(t, filepath.Join(root, "mod2"),
+		"history/states/2.txt",
+		"localisation/english/b_l_english.yml",
+		"localisation/english/replace/e_l_english.yml",
+	)
+
+	tests := []struct {
+		name     string
+		replace1 []string // replace_path entries of mod1.
+		replace2 []string // replace_path entries of mod2.
+		path     string   // Path("map/definition.csv").
+		states   string   // Glob("history/states", "*.txt").
+		loc      string   // GlobAll("localisation", "*.yml").
+	}{
+		{
+			name:   "overrides",
+			path:   "mod1/map/definition.csv",
+			states: "game/history/states/1.txt mod2/history/states/2.txt mod1/history/states/3.txt",
+			loc:    "game/localisation/c_l_english.yml game/localisation/english/a_l_english.yml mod2/localisation/english/b_l_english.yml mod1/localisation/english/d_l_english.yml mod2/localisation/english/replace/e_l_english.yml",
+		},
+		{
+			name:     "replaced folder",
+			replace1: []string{"history/states", "map"},
+			path:     "mod1/map/definition.csv",
+			states:   "mod2/history/states/2.txt mod1/history/states/3.txt",
+			loc:      "game/localisation/c_l_english.yml game/localisation/english/a_l_english.yml mod2/localisation/english/b_l_english.yml mod1/localisation/english/d_l_english.yml mod2/localisation/english/replace/e_l_english.yml",
+		},
+		{
+			name:     "replaced by the last mod",
+			replace2: []string{"history/states/", "map"},
+			path:     "",
+			states:   "mod2/history/states/2.txt",
+		},
+		{
+			name:     "replaced subfolder",
+			replace1: []string{"localisation/english"},
+			path:     "mod1/map/definition.csv",
+			states:   "game/history/states/1.txt mod2/history/states/2.txt mod1/history/states/3.txt",
+			loc:      "game/localisation/c_l_english.yml mod2/localisation/english/b_l_english.yml mod1/localisation/english/d_l_english.yml mod2/localisation/english/replace/e_l_english.yml",
+		},
+		{
+			name:     "replaced parent folder",
+			replace2: []string{"localisation"},
+			path:     "mod1/map/definition.csv",
+			states:   "game/history/states/1.txt mod2/history/states/2.txt mod1/history/states/3.txt",
+			loc:      "mod2/localisation/english/b_l_english.yml mod2/localisation/english/replace/e_l_english.yml",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := &modFileSystem{Layers: []*modLayer{
+				{Name: "base game", Path: filepath.Join(root, "game")},
+				{Name: "mod1", Path: filepath.Join(root, "mod1")},
+				{Name: "mod2", Path: filepath.Join(root, "mod2")},
+			}}
+			for _, rp := range tt.replace1 {
+				fs.Layers[1].ReplacePaths = append(fs.Layers[1].ReplacePaths, cleanRelPath(rp))
+			}
+			for _, rp := range tt.replace2 {
+				fs.Layers[2].ReplacePaths = append(fs.Layers[2].ReplacePaths, cleanRelPath(rp))
+			}
+
+			p, err := fs.Path("map/definition.csv")
+			if tt.path == "" {
+				if err == nil {
+					t.Errorf("Path: got %v, want not found error", p)
+				}
+			} else if err != nil || relPaths(t, root, []string{p}) != tt.path {
+				t.Errorf("Path: got %v, %v, want %v", p, err, tt.path)
+			}
+
+			states, err := fs.Glob("history/states", "*.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := relPaths(t, root, states); got != tt.states {
+				t.Errorf("Glob:\ngot  %v\nwant %v", got, tt.states)
+			}
+
+			if tt.loc == "" {
+				return
+			}
+			loc, err := fs.GlobAll("localisation", "*.yml")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := relPaths(t, root, loc); got != tt.loc {
+				t.Errorf("GlobAll:\ngot  %v\nwant %v", got, tt.loc)
+			}
+		})
+	}
+}
+
+func TestNewModLayer(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, root, "mod/folder/map/definition.csv", "mod/plain/map/definition.csv")
+	err := ioutil.WriteFile(filepath.Join(root, "mod", "folder", "descriptor.mod"), []byte("name = \"Folder\"\nreplace_path = \"history/states\"\nreplace_path = \"map/strategicregions/\"\n"), 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(root, "mod", "launcher.mod"), []byte("name = \"Launcher\"\npath = \"mod/folder\"\nreplace_path = \"common\"\n"), 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(root, "mod", "archive.mod"), []byte("name = \"Archive\"\narchive = \"mod/a.zip\"\n"), 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path    string
+		name    string
+		dir     string
+		replace string
+		err     bool
+	}{
+		{path: "mod/folder", name: "Folder", dir: "mod/folder", replace: "history/states map/strategicregions"},
+		{path: "mod/plain", name: "plain", dir: "mod/plain"},
+		{path: "mod/launcher.mod", name: "Launcher", dir: "mod/folder", replace: "common"},
+		{path: "mod/archive.mod", err: true},
+		{path: "mod/missing", err: true},
+	}
+
+	for _, tt := range tests {
+		l, err := newModLayer(filepath.Join(root, filepath.FromSlash(tt.path)))
+		if tt.err {
+			if err == nil {
+				t.Errorf("%v: expected error", tt.path)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", tt.path, err)
+			continue
+		}
+		if l.Name != tt.name || relPaths(t, root, []string{l.Path}) != tt.dir || strings.Join(l.ReplacePaths, " ") != tt.replace {
+			t.Errorf("%v: got name %q, path %v, replace_path %v", tt.path, l.Name, l.Path, l.ReplacePaths)
+		}
+	}
+}
+
+func TestModLayerReplaces(t *testing.T) {
+	l := &modLayer{ReplacePaths: []string{"history/states", "localisation/english"}}
+	tests := []struct {
+		dir  string
+		want bool
+	}{
+		{"history/states", true},
+		{"history/states/sub", true},
+		{"history/states2", false},
+		{"history", false},
+		{"localisation", false},
+		{"localisation/english/replace", true},
+	}
+	for _, tt := range tests {
+		if got := l.Replaces(tt.dir); got != tt.want {
+			t.Errorf("Replaces(%q): got %v, want %v", tt.dir, got, tt.want)
+		}
+	}
+}
+
+func TestNewModFileSystem(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, root,
+		"game/history/states/1.txt",
+		"game/history/states/2.txt",
+		"mods/a/history/states/2.txt",
+		"mods/b/history/states/3.txt",
+	)
+	err := ioutil.WriteFile(filepath.Join(root, "mods", "b", "descriptor.mod"), []byte("name = \"B\"\nreplace_path = \"history/states\"\n"), 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+	game := filepath.Join(root, "game")
+	modA := filepath.Join(root, "mods", "a")
+	modB := filepath.Join(root, "mods", "b")
+
+	tests := []struct {
+		name   string
+		game   string
+		mods   []string
+		layers string // Layer names in load order.
+		states string // Glob("history/states", "*.txt").
+		err    bool
+	}{
+		{name: "game", game: game, layers: `"base game"`, states: "game/history/states/1.txt game/history/states/2.txt"},
+		{name: "game and mod", game: game, mods: []string{modA}, layers: `"base game", "a"`, states: "game/history/states/1.txt mods/a/history/states/2.txt"},
+		// The replace_path of the last mod hides the files of the game and the first mod.
+		{name: "load order", game: game, mods: []string{modA, modB}, layers: `"base game", "a", "B"`, states: "mods/b/history/states/3.txt"},
+		{name: "replacing mod first", game: game, mods: []string{modB, modA}, layers: `"base game", "B", "a"`, states: "mods/a/history/states/2.txt mods/b/history/states/3.txt"},
+		{name: "mods only", mods: []string{modA}, layers: `"a"`, states: "mods/a/history/states/2.txt"},
+		{name: "missing mod", game: game, mods: []string{filepath.Join(root, "mods", "missing")}, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, err := newModFileSystem(tt.game, tt.mods)
+			if tt.err {
+				if err == nil {
+					t.Errorf("expected error, got layers %v", fs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := fs.String(); got != tt.layers {
+				t.Errorf("got layers %v, want %v", got, tt.layers)
+			}
+			states, err := fs.Glob("history/states", "*.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := relPaths(t, root, states); got != tt.states {
+				t.Errorf("got states %v, want %v", got, tt.states)
+			}
+		})
+	}
+}
+
+func TestGlobInputFiles(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, root, "game/history/states/1.txt", "mod/history/states/2.txt", "other/3.txt", "other/4.yml")
+	oldFS := gameFS
+	defer func() { gameFS = oldFS }()
+	var err error
+	gameFS, err = newModFileSystem(filepath.Join(root, "game"), []string{filepath.Join(root, "mod")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An explicit folder replaces the merged game and mod folders.
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"", "game/history/states/1.txt mod/history/states/2.txt"},
+		{filepath.Join(root, "other"), "other/3.txt"},
+	}
+	for _, tt := range tests {
+		files, err := globInputFiles(tt.path, "history/states", "*.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := relPaths(t, root, files); got != tt.want {
+			t.Errorf("%q: got %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+module github.com/malashin/hoi4geoparser
+
+go 1.23.0
+
+require (
+	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0
+	github.com/jsummers/gobmp v0.0.0-20230614200233-a9de23ed2e25
+	golang.org/x/image v0.25.0
+)
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GameDate represents an in-game date like "1936.1.1".
+type gameDate struct {
+	Year  int
+	Month int
+	Day   int
+}
+
+// DatedHistory is a dated entry of a state history block like "1939.1.1 = { owner = GER }".
+type datedHistory struct {
+	Date  gameDate
+	Block *scriptNode
+}
+
+// ParseGameDate parses dates in "year.month.day" format.
+// An optional fourth hour field is ignored.
+func parseGameDate(s string) (d gameDate, err error) {
+	fields := strings.Split(s, ".")
+	if len(fields) < 3 || len(fields) > 4 {
+		return d, fmt.Errorf("invalid date %q", s)
+	}
+	values := make([]int, 3)
+	for i := range values {
+		values[i], err = strconv.Atoi(fields[i])
+		if err != nil {
+			return d, fmt.Errorf("invalid date %q", s)
+		}
+	}
+	d = gameDate{values[0], values[1], values[2]}
+	if d.Month < 1 || d.Month > 12 || d.Day < 1 || d.Day > 31 {
+		return d, fmt.Errorf("invalid date %q", s)
+	}
+	return d, nil
+}
+
+// Before reports whether d is earlier than e.
+func (d gameDate) Before(e gameDate) bool {
+	if d.Year != e.Year {
+		return d.Year < e.Year
+	}
+	if d.Month != e.Month {
+		return d.Month < e.Month
+	}
+	return d.Day < e.Day
+}
+
+func (d gameDate) String() string {
+	return fmt.Sprintf("%v.%v.%v", d.Year, d.Month, d.Day)
+}
+
+// Set parses s into d so gameDate can be used as a flag.Value.
+func (d *gameDate) Set(s string) (err error) {
+	*d, err = parseGameDate(s)
+	return err
+}
+
+// CollectStateHistory stores the undated history blocks of a state
+// and every dated entry inside them.
+func collectStateHistory(state *State, n *scriptNode) error {
+	if !n.IsBlock {
+		return newFileError(n.Pos, "%v: expected block", n.Key)
+	}
+	state.History = append(state.History, n)
+	for _, c := range n.Children {
+		if !isDateKey(c.Key) {
+			continue
+		}
+		date, err := parseGameDate(c.Key)
+		if err != nil {
+			return newFileError(c.Pos, "%v", err)
+		}
+		if !c.IsBlock {
+			return newFileError(c.Pos, "%v: expected block", c.Key)
+		}
+		state.DatedHistory = append(state.DatedHistory, datedHistory{Date: date, Block: c})
+	}
+	return nil
+}
+
+// IsDateKey reports whether a history key looks like a date.
+func isDateKey(s string) bool {
+	return len(s) > 0 && s[0] >= '0' && s[0] <= '9' && strings.Contains(s, ".")
+}
+
+// EvaluateStateHistory resets the state history fields and applies
+// the undated history followed by every dated entry up to the given date.
+func evaluateStateHistory(state *State, date gameDate) error {
+	state.Owner = ""
+	state.Controller = ""
+	state.ExtraBuildingSlots = 0
+	state.Cores = nil
+	state.Claims = nil
+	state.Buildings = make(map[string]int)
+	state.ProvinceBuildings = make(map[int]map[string]int)
+	state.VictoryPoints = make(map[int]int)
+
+	for _, h := range state.History {
+		err := applyStateHistory(state, h)
+		if err != nil {
+			return err
+		}
+	}
+
+	dated := make([]datedHistory, 0, len(state.DatedHistory))
+	for _, h := range state.DatedHistory {
+		if !date.Before(h.Date) {
+			dated = append(dated, h)
+		}
+	}
+	sort.SliceStable(dated, func(i, j int) bool { return dated[i].Date.Before(dated[j].Date) })
+	for _, h := range dated {
+		err := applyStateHistory(state, h.Block)
+		if err != nil {
+			return err
+		}
+	}
+
+	state.Infrastructure = state.Buildings["infrastructure"]
+	state.BuildingSlots = state.ExtraBuildingSlots
+	if c, ok := stateCategoriesMap[state.Category]; ok {
+		state.BuildingSlots += c.BuildingSlots
+	}
+	return nil
+}
+
+// ApplyStateHistory applies the entries of a state history block in file order.
+// Dated entries like "1939.1.1 = { ... }" are skipped.
+func applyStateHistory(state *State, n *scriptNode) error {
+	for _, c := range n.Children {
+		if isDateKey(c.Key) {
+			continue
+		}
+
+		var err error
+		switch strings.ToLower(c.Key) {
+		case "owner":
+			// Changing the owner also transfers control of the state.
+			state.Owner = c.Value
+			state.Controller = c.Value
+		case "controller":
+			state.Controller = c.Value
+		case "add_core_of":
+			state.Cores = addTag(state.Cores, c.Value)
+		case "remove_core_of":
+			state.Cores = removeTag(state.Cores, c.Value)
+		case "add_claim_by":
+			state.Claims = addTag(state.Claims, c.Value)
+		case "remove_claim_by":
+			state.Claims = removeTag(state.Claims, c.Value)
+		case "add_extra_state_shared_building_slots":
+			var slots int
+			slots, err = c.Int()
+			state.ExtraBuildingSlots += slots
+		case "victory_points":
+			err = parseVictoryPoints(state, c)
+		case "buildings":
+			err = parseStateBuildings(state, c)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseVictoryPoints reads "victory_points = { <province> <value> }".
+func parseVictoryPoints(state *State, n *scriptNode) error {
+	values, err := n.Ints()
+	if err != nil {
+		return err
+	}
+	if len(values)%2 != 0 {
+		return newFileError(n.Pos, "victory_points: expected province and value pairs")
+	}
+	for i := 0; i < len(values); i += 2 {
+		state.VictoryPoints[values[i]] = values[i+1]
+	}
+	return nil
+}
+
+// ParseStateBuildings reads state buildings like "arms_factory = 2"
+// and province buildings like "1234 = { naval_base = 3 }".
+func parseStateBuildings(state *State, n *scriptNode) error {
+	if !n.IsBlock {
+		return newFileError(n.Pos, "buildings: expected block")
+	}
+
+	for _, c := range n.Children {
+		if !c.IsBlock {
+			level, err := c.Int()
+			if err != nil {
+				return err
+			}
+			state.Buildings[strings.ToLower(c.Key)] = level
+			continue
+		}
+
+		pID, err := strconv.Atoi(c.Key)
+		if err != nil {
+			return newFileError(c.Pos, "buildings: expected province ID, found %q", c.Key)
+		}
+		if state.ProvinceBuildings[pID] == nil {
+			state.ProvinceBuildings[pID] = make(map[string]int)
+		}
+		for _, b := range c.Children {
+			level, err := b.Int()
+			if err != nil {
+				return err
+			}
+			state.ProvinceBuildings[pID][strings.ToLower(b.Key)] = level
+		}
+	}
+	return nil
+}
+
+func addTag(tags []string, tag string) []string {
+	for _, t := range tags {
+		if t == tag {
+			return tags
+		}
+	}
+	return append(tags, tag)
+}
+
+func removeTag(tags []string, tag string) []string {
+	for i, t := range tags {
+		if t == tag {
+			return append(tags[:i:i], tags[i+1:]...)
+		}
+	}
+	return tags
+}
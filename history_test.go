@@ -0,0 +1,169 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseGameDate(t *testing.T) {
+	tests := []struct {
+		s    string
+		want gameDate
+		err  bool
+	}{
+		{s: "1936.1.1", want: gameDate{1936, 1, 1}},
+		{s: "1939.9.1.12", want: gameDate{1939, 9, 1}},
+		{s: "1936.13.1", err: true},
+		{s: "1936.1.0", err: true},
+		{s: "1936.1", err: true},
+		{s: "1936.a.1", err: true},
+		{s: "1.2.3.4.5", err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseGameDate(tt.s)
+		if (err != nil) != tt.err || (!tt.err && got != tt.want) {
+			t.Errorf("%q: got %v, %v", tt.s, got, err)
+		}
+	}
+}
+
+func TestGameDateBefore(t *testing.T) {
+	tests := []struct {
+		d, e gameDate
+		want bool
+	}{
+		{gameDate{1936, 1, 1}, gameDate{1936, 1, 2}, true},
+		{gameDate{1936, 2, 1}, gameDate{1936, 1, 31}, false},
+		{gameDate{1935, 12, 31}, gameDate{1936, 1, 1}, true},
+		{gameDate{1936, 1, 1}, gameDate{1936, 1, 1}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.d.Before(tt.e); got != tt.want {
+			t.Errorf("%v before %v: got %v", tt.d, tt.e, got)
+		}
+	}
+}
+
+const testStateHistory = `
+history = {
+	owner = AAA
+	add_core_of = AAA
+	add_claim_by = BBB
+	victory_points = { 1 5 }
+	buildings = {
+		infrastructure = 2
+		arms_factory = 1
+		1 = { naval_base = 3 }
+	}
+	add_extra_state_shared_building_slots = 1
+	1939.1.1 = {
+		owner = BBB
+		add_core_of = BBB
+		remove_core_of = AAA
+		buildings = { infrastructure = 4 }
+	}
+	1938.6.1 = {
+		controller = CCC
+		remove_claim_by = BBB
+		victory_points = { 1 10 2 1 }
+	}
+}
+history = {
+	1940.1.1 = { add_extra_state_shared_building_slots = 2 }
+}
+`
+
+func TestEvaluateStateHistory(t *testing.T) {
+	root, err := parseScript("f", []byte(testStateHistory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := &State{Category: "town"}
+	for _, h := range root.FindAll("history") {
+		err = collectStateHistory(state, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	oldCategories := stateCategoriesMap
+	defer func() { stateCategoriesMap = oldCategories }()
+	stateCategoriesMap = map[string]*StateCategory{"town": {Name: "town", BuildingSlots: 4}}
+
+	tests := []struct {
+		date           gameDate
+		owner          string
+		controller     string
+		cores          string
+		claims         string
+		infrastructure int
+		slots          int
+		vps            map[int]int
+	}{
+		{gameDate{1936, 1, 1}, "AAA", "AAA", "AAA", "BBB", 2, 5, map[int]int{1: 5}},
+		{gameDate{1938, 6, 1}, "AAA", "CCC", "AAA", "", 2, 5, map[int]int{1: 10, 2: 1}},
+		// A later owner change also takes the control back.
+		{gameDate{1939, 1, 1}, "BBB", "BBB", "BBB", "", 4, 5, map[int]int{1: 10, 2: 1}},
+		{gameDate{1940, 1, 1}, "BBB", "BBB", "BBB", "", 4, 7, map[int]int{1: 10, 2: 1}},
+		// Evaluating an earlier date again resets the state.
+		{gameDate{1936, 1, 1}, "AAA", "AAA", "AAA", "BBB", 2, 5, map[int]int{1: 5}},
+	}
+
+	for _, tt := range tests {
+		err := evaluateStateHistory(state, tt.date)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.date, err)
+		}
+		if state.Owner != tt.owner || state.Controller != tt.controller {
+			t.Errorf("%v: got owner %v, controller %v", tt.date, state.Owner, state.Controller)
+		}
+		if got := strings.Join(state.Cores, " "); got != tt.cores {
+			t.Errorf("%v: got cores %q, want %q", tt.date, got, tt.cores)
+		}
+		if got := strings.Join(state.Claims, " "); got != tt.claims {
+			t.Errorf("%v: got claims %q, want %q", tt.date, got, tt.claims)
+		}
+		if state.Infrastructure != tt.infrastructure || state.BuildingSlots != tt.slots {
+			t.Errorf("%v: got infrastructure %v, building slots %v", tt.date, state.Infrastructure, state.BuildingSlots)
+		}
+		if state.Buildings["arms_factory"] != 1 || state.ProvinceBuildings[1]["naval_base"] != 3 {
+			t.Errorf("%v: got buildings %v, province buildings %v", tt.date, state.Buildings, state.ProvinceBuildings)
+		}
+		if len(state.VictoryPoints) != len(tt.vps) {
+			t.Errorf("%v: got victory points %v, want %v", tt.date, state.VictoryPoints, tt.vps)
+		}
+		for p, v := range tt.vps {
+			if state.VictoryPoints[p] != v {
+				t.Errorf("%v: got victory points %v, want %v", tt.date, state.VictoryPoints, tt.vps)
+				break
+			}
+		}
+	}
+}
+
+func TestEvaluateStateHistoryErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"history = { 1936.13.1 = { owner = AAA } }", `f:1:13: invalid date "1936.13.1"`},
+		{"history = { 1936.1.1 = AAA }", "f:1:13: 1936.1.1: expected block"},
+		{"history = { victory_points = { 1 } }", "f:1:13: victory_points: expected province and value pairs"},
+		{"history = { buildings = { x = { naval_base = 1 } } }", `f:1:27: buildings: expected province ID, found "x"`},
+		{"history = { buildings = { infrastructure = x } }", `f:1:27: infrastructure: expected integer, found "x"`},
+	}
+	for _, tt := range tests {
+		root, err := parseScript("f", []byte(tt.src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		state := &State{}
+		err = collectStateHistory(state, root.Find("history"))
+		if err == nil {
+			err = evaluateStateHistory(state, startDate)
+		}
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%q: got error %v, want %q", tt.src, err, tt.err)
+		}
+	}
+}
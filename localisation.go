@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ParseLocalisationFiles reads every localisation/**/*_l_<language>.yml file.
+// Files in "replace" folders are read last, so their keys override the others.
+func parseLocalisationFiles() error {
+	fmt.Printf("%s: Parsing %v localisation files...\n", time.Since(startTime), language)
+	var files []string
+	if localisationPath != "" {
+		err := filepath.Walk(filepath.FromSlash(localisationPath), func(p string, fi os.FileInfo, err error) error {
+			if err == nil && !fi.IsDir() && strings.HasSuffix(fi.Name(), "_l_"+language+".yml") {
+				files = append(files, p)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		var err error
+		files, err = gameFS.GlobAll("localisation", "*_l_"+language+".yml")
+		if err != nil {
+			return err
+		}
+	}
+
+	var normalFiles, replaceFiles []string
+	for _, path := range files {
+		if strings.Contains(filepath.ToSlash(path), "/replace/") {
+			replaceFiles = append(replaceFiles, path)
+		} else {
+			normalFiles = append(normalFiles, path)
+		}
+	}
+	for _, path := range append(normalFiles, replaceFiles...) {
+		warnings, err := parseLocalisationFile(path)
+		if err != nil {
+			return err
+		}
+		for _, w := range warnings {
+			fmt.Printf("%s: %v\n", time.Since(startTime), w)
+		}
+	}
+	return nil
+}
+
+// ParseLocalisationFile reads a Paradox localisation file with lines like
+// ` STATE_1:0 "Name"` under the `l_<language>:` header.
+// Broken lines are skipped and returned as warnings, as the game does,
+// and a file without the header is skipped entirely.
+func parseLocalisationFile(path string) (warnings []error, err error) {
+	lines, err := readLines(filepath.FromSlash(path))
+	if err != nil {
+		return nil, err
+	}
+
+	header := false
+	for i, line := range lines {
+		pos := filePos{Path: path, Line: i + 1}
+		s := strings.TrimSpace(line)
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		if !header {
+			// The header may be followed by a comment, as in "l_english: # comment".
+			if c := strings.Index(s, "#"); c >= 0 {
+				s = strings.TrimSpace(s[:c])
+			}
+			if s != "l_"+language+":" {
+				return []error{newFileError(pos, "expected \"l_%v:\" header, found %q, the file is skipped", language, s)}, nil
+			}
+			header = true
+			continue
+		}
+
+		colon := strings.Index(s, ":")
+		if colon < 1 {
+			warnings = append(warnings, newFileError(pos, "missing key in %q, the line is skipped", s))
+			continue
+		}
+		key := s[:colon]
+
+		// Skip the optional version number and take everything up to
+		// the matching closing quote as the value, so a trailing comment
+		// is ignored even if it has quotes in it.
+		first := strings.Index(s, "\"")
+		last := -1
+		if first > colon && !strings.Contains(s[:first], "#") {
+			for j := first + 1; j < len(s); j++ {
+				if s[j] == '\\' {
+					j++
+					continue
+				}
+				if s[j] == '"' {
+					last = j
+					break
+				}
+			}
+		}
+		if last < 0 {
+			warnings = append(warnings, newFileError(pos, "%v: missing quoted value, the line is skipped", key))
+			continue
+		}
+		localisationMap[key] = strings.Replace(s[first+1:last], "\\\"", "\"", -1)
+	}
+	return warnings, nil
+}
+
+// LocaliseNames parses localisation files on first use
+// and fills in the localised names of states and strategic regions.
+// Names without localisation keep their keys.
+func localiseNames() error {
+	if localisationLoaded {
+		return nil
+	}
+	err := parseLocalisationFiles()
+	if err != nil {
+		return err
+	}
+	localisationLoaded = true
+
+	for _, s := range statesMap {
+		s.LocalisedName = localise(s.Name)
+	}
+	for _, r := range strategicRegionMap {
+		r.LocalisedName = localise(r.Name)
+	}
+	return nil
+}
+
+func localise(key string) string {
+	if s, ok := localisationMap[key]; ok {
+		return s
+	}
+	return key
+}
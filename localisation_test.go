@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseLocalisationFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		want     map[string]string
+		warnings []string
+	}{
+		{
+			name: "keys",
+			src:  "\xEF\xBB\xBFl_english:\n # comment\n STATE_1:0 \"North\"\n STATE_2: \"Old \\\"South\\\"\" # comment\n\n STATE_3:10 \"\"\n",
+			want: map[string]string{"STATE_1": "North", "STATE_2": `Old "South"`, "STATE_3": ""},
+		},
+		{
+			name:     "trailing comments",
+			src:      "l_english:\n STATE_1:0 \"North\" # old \"x\"\n STATE_2:0 \"A \\\"B\\\"\"#\"c\"\n STATE_3:0 # \"East\"\n STATE_4:0 \"West\n",
+			want:     map[string]string{"STATE_1": "North", "STATE_2": `A "B"`},
+			warnings: []string{"/l.yml:4: STATE_3: missing quoted value, the line is skipped", "/l.yml:5: STATE_4: missing quoted value, the line is skipped"},
+		},
+		{
+			name: "header comment",
+			src:  "l_english: # comment\n STATE_1:0 \"North\"\n",
+			want: map[string]string{"STATE_1": "North"},
+		},
+		{
+			name:     "header comment in another language",
+			src:      "l_german:# comment\n STATE_1:0 \"Nord\"\n",
+			want:     map[string]string{},
+			warnings: []string{`/l.yml:1: expected "l_english:" header, found "l_german:", the file is skipped`},
+		},
+		{
+			name:     "missing header",
+			src:      " STATE_1:0 \"North\"\n",
+			want:     map[string]string{},
+			warnings: []string{`/l.yml:1: expected "l_english:" header, found "STATE_1:0 \"North\"", the file is skipped`},
+		},
+		{
+			name:     "broken lines",
+			src:      "l_english:\n :0 \"North\"\n STATE_1:0 North\n STATE_2:0 \"South\"\n STATE_3 \"East\n",
+			want:     map[string]string{"STATE_2": "South"},
+			warnings: []string{`/l.yml:2: missing key in ":0 \"North\"", the line is skipped`, "/l.yml:3: STATE_1: missing quoted value, the line is skipped", `/l.yml:5: missing key in "STATE_3 \"East", the line is skipped`},
+		},
+	}
+
+	oldLanguage, oldMap := language, localisationMap
+	defer func() { language, localisationMap = oldLanguage, oldMap }()
+	language = "english"
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			localisationMap = make(map[string]string)
+			dir := t.TempDir()
+			path := filepath.ToSlash(filepath.Join(dir, "l.yml"))
+			err := ioutil.WriteFile(filepath.FromSlash(path), []byte(tt.src), 0664)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			warnings, err := parseLocalisationFile(path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var want []string
+			for _, w := range tt.warnings {
+				want = append(want, filepath.ToSlash(dir)+w)
+			}
+			if len(warnings) != len(want) {
+				t.Errorf("got warnings %v, want %q", warnings, want)
+			}
+			for i := 0; i < len(warnings) && i < len(want); i++ {
+				if warnings[i].Error() != want[i] {
+					t.Errorf("got warning %q, want %q", warnings[i], want[i])
+				}
+			}
+			if len(localisationMap) != len(tt.want) {
+				t.Errorf("got %v keys, want %v", len(localisationMap), len(tt.want))
+			}
+			for k, v := range tt.want {
+				if localisationMap[k] != v {
+					t.Errorf("%v: got %q, want %q", k, localisationMap[k], v)
+				}
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"errors"
+	"flag"
 	"fmt"
 	"image"
 	"image/color"
@@ -12,11 +13,11 @@ import (
 	"math/rand"
 	"os"
 	"path/filepath"
-	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/golang/freetype"
 	bmp "github.com/jsummers/gobmp"
@@ -24,27 +25,35 @@ import (
 	"golang.org/x/image/font"
 )
 
-var modPath = "c:/Users/admin/Documents/Paradox Interactive/Hearts of Iron IV/mod/oldworldblues"
-
-// var modPath = "d:/Games/SteamApps/common/Hearts of Iron IV"
-var definitionsPath = modPath + "/map/definition.csv"
-var adjacenciesPath = modPath + "/map/adjacencies.csv"
-var provincesPath = modPath + "/map/provinces.bmp"
-var terrainPath = modPath + "/map/terrain.bmp"
-var heightmapPath = modPath + "/map/heightmap.bmp"
-var statesPath = modPath + "/history/states"
-var strategicRegionPath = modPath + "/map/strategicregions"
+var gamePath string
+var modPaths stringList
+var gameFS *modFileSystem
+var outputPath string
+var definitionsPath string
+var adjacenciesPath string
+var adjacencyRulesPath string
+var provincesPath string
+var terrainPath string
+var terrainCategoriesPath string
+var heightmapPath string
+var statesPath string
+var strategicRegionPath string
+var stateCategoryPath string
+var countryTagsPath string
+var countriesPath string
+var localisationPath string
+var language string
+var fontPath string
+var centerMethod = "pole"
+var startDate = gameDate{1936, 1, 1}
 var provincesIDMap = make(map[int]*Province)
 var provincesRGBMap = make(map[color.Color]*Province)
 var statesMap = make(map[int]*State)
+var stateCategoriesMap = make(map[string]*StateCategory)
+var countriesMap = make(map[string]*Country)
+var localisationMap = make(map[string]string)
+var localisationLoaded bool
 var strategicRegionMap = make(map[int]*StrategicRegion)
-var rStateID = regexp.MustCompile(`(?:id[ \n\t]*?=[ \n\t]*?(\d+))`)
-var rStateName = regexp.MustCompile(`(?:name[ \n\t]*?=[ \n\t]*?\"(.+?)\")`)
-var rStateManpower = regexp.MustCompile(`(?:manpower[ \n\t]*?=[ \n\t]*?(\d+))`)
-var rStateProvinces = regexp.MustCompile(`(?s:provinces[ \n\t]*?=[ \n\t]*?{.*?([0-9 ]+).*?})`)
-var rStateInfrastructure = regexp.MustCompile(`(?:infrastructure[ \n\t]*?=[ \n\t]*?(\d+))`)
-var rStateImpassable = regexp.MustCompile(`(?:impassable[ \n\t]*?=[ \n\t]*?yes)`)
-var rSpace = regexp.MustCompile(`\s+`)
 var mapScalePixelToKm = 7.114
 var provincesImageSize image.Rectangle
 var waterColor = color.RGBA{68, 107, 163, 255}
@@ -56,6 +65,7 @@ var utf8bom = []byte{0xEF, 0xBB, 0xBF}
 // Province represents an in-game province with all parsed data in it.
 type Province struct {
 	ID              int
+	Line            int // Line in definition.csv.
 	RGB             color.RGBA
 	Type            string // "land", "sea" or "lake"
 	IsCoastal       bool
@@ -69,68 +79,118 @@ type Province struct {
 	AdjacentTo      map[int]*Province
 	ConnectedTo     map[int]*Province
 	ImpassableTo    map[int]*Province
+	Adjacencies     []*Adjacency // Entries of adjacencies.csv with the province on either end.
+	VictoryPoints   int
+	Buildings       map[string]int // Province buildings like "naval_base" or "bunker".
 	RenderColor     color.RGBA
 }
 
 // State represents an in-game state with all parsed data in it.
 type State struct {
-	ID             int
-	Name           string
-	Manpower       int
-	Infrastructure int
-	IsCoastal      bool
-	IsImpassable   bool
-	Continent      int
-	PixelCoords    []image.Point
-	PixelCoordsMap map[image.Point]bool
-	CenterPoint    image.Point
-	Provinces      map[int]*Province
-	DistanceTo     map[int]int // Distance to other states.
-	AdjacentTo     map[int]*State
-	ConnectedTo    map[int]*State
-	ImpassableTo   map[int]*State
-	RenderColor    color.RGBA
+	ID                 int
+	Pos                filePos // Position of the state block.
+	Name               string
+	LocalisedName      string
+	Manpower           int
+	Infrastructure     int
+	IsCoastal          bool
+	IsImpassable       bool
+	Continent          int
+	Category           string
+	BuildingSlots      int // Local building slots of the category plus extra slots from history.
+	ExtraBuildingSlots int // Slots from add_extra_state_shared_building_slots in history.
+	Resources          map[string]float64
+	Owner              string
+	Controller         string
+	Cores              []string
+	Claims             []string
+	Buildings          map[string]int         // State buildings like "arms_factory" or "air_base".
+	ProvinceBuildings  map[int]map[string]int // Province ID to its buildings.
+	VictoryPoints      map[int]int            // Province ID to its victory points.
+	History            []*scriptNode          // Undated history blocks.
+	DatedHistory       []datedHistory
+	PixelCoords        []image.Point
+	PixelCoordsMap     map[image.Point]bool
+	CenterPoint        image.Point
+	Provinces          map[int]*Province
+	DistanceTo         map[int]int // Distance to other states.
+	AdjacentTo         map[int]*State
+	ConnectedTo        map[int]*State
+	ImpassableTo       map[int]*State
+	Adjacencies        []*Adjacency // Entries of adjacencies.csv with a province of the state on either end.
+	RenderColor        color.RGBA
 }
 
 // StrategicRegion represents an in-game strategic_region with all parsed data in it.
 type StrategicRegion struct {
 	ID             int
+	Pos            filePos // Position of the strategic_region block.
 	Name           string
+	LocalisedName  string
 	Provinces      map[int]*Province
 	PixelCoords    []image.Point
 	PixelCoordsMap map[image.Point]bool
 	CenterPoint    image.Point
+	IsSea          bool // All provinces of the region are sea provinces.
+	AdjacentTo     map[int]*StrategicRegion
+	ConnectedTo    map[int]*StrategicRegion // Regions connected by sea or land adjacencies.
 }
 
 func main() {
 	// Track start time for benchmarking.
 	startTime = time.Now()
 
-	// Parse  definition.csv for provinces.
-	err := parseDefinitions()
+	// Parse command-line flags and resolve input paths.
+	commands, err := parseFlags(os.Args[1:])
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(2)
 	}
 
-	// Parse  adjacencies.csv for province connections and impassable borders.
-	err = parseAdjacencies()
+	// Parse  definition.csv for provinces.
+	err = parseDefinitions()
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
 	// Parse provinces.bmp for province adjacency.
 	err = parseProvinces()
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// Parse adjacency_rules.txt for rule-controlled crossings.
+	err = parseAdjacencyRules()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// Parse  adjacencies.csv for province connections, impassable borders and crossings.
+	err = parseAdjacencies()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
 	// Find the center points of each province.
 	findProvincesCenterPoints()
 
+	// Parse state categories.
+	err = parseStateCategoryFiles()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
 	// Parse state files.
 	err = parseStateFiles()
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
 	// Parse states provinces.
@@ -142,105 +202,118 @@ func main() {
 	// Parse state files.
 	err = parseStrategicRegionFiles()
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
 	// Parse strategic regions provinces.
 	parseStrategicRegionsProvinces()
 
-	// Write the output file.
-	err = saveGeoData()
-	if err != nil {
-		panic(err)
+	// Run the selected commands.
+	for _, c := range commands {
+		err = c.Run()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v %v: %v\n", c.Group, c.Name, err)
+			os.Exit(1)
+		}
 	}
 
-	// // Generate state ID map.
-	// err = generateSateMap()
-	// if err != nil {
-	// 	panic(err)
-	// }
-
-	// // Generate state ID map.
-	// err = generateColoredSateMap()
-	// if err != nil {
-	// 	panic(err)
-	// }
-
-	// // Generate state ID map.
-	// err = generateSateIDMap()
-	// if err != nil {
-	// 	panic(err)
-	// }
-
-	// // Generate province map.
-	// err = generateProvinceMap()
-	// if err != nil {
-	// 	panic(err)
-	// }
-
-	// // Generate province ID map.
-	// err = generateProvinceIDMap()
-	// if err != nil {
-	// 	panic(err)
-	// }
-
-	// // Generate manpower map.
-	// err = generateManpowerMap()
-	// if err != nil {
-	// 	panic(err)
-	// }
-
-	// // Generate sea province map.
-	// err = generateSeaProvinceMap()
-	// if err != nil {
-	// 	panic(err)
-	// }
-
-	// // Generate province-based terrain map.
-	// err = generateProvinceBasedTerrainMap()
-	// if err != nil {
-	// 	panic(err)
-	// }
-
-	// // Generate province-based heightmap threshold map.
-	// err = generateProvinceBasedHeightmapThresholdMap()
-	// if err != nil {
-	// 	panic(err)
-	// }
+	// Print out elapsed time.
+	elapsedTime := time.Since(startTime)
+	fmt.Printf("Elapsed time: %s\n", elapsedTime)
+}
 
-	// // Generate infrastructure map.
-	// err = generateInfrastructureMap()
-	// if err != nil {
-	// 	panic(err)
-	// }
+// ParseFlags reads the command-line flags from args, fills in every input path
+// that was not set explicitly and returns the selected commands.
+func parseFlags(args []string) ([]*command, error) {
+	commands := newCommands()
+
+	flag.StringVar(&gamePath, "game", "", "path to the base game folder")
+	flag.Var(&modPaths, "mod", "path to the mod folder or .mod descriptor, can be repeated in load order")
+	flag.StringVar(&outputPath, "out", ".", "path to the output folder")
+	flag.StringVar(&definitionsPath, "definitions", "", "override path to map/definition.csv")
+	flag.StringVar(&adjacenciesPath, "adjacencies", "", "override path to map/adjacencies.csv")
+	flag.StringVar(&adjacencyRulesPath, "adjacencyrules", "", "override path to map/adjacency_rules.txt")
+	flag.StringVar(&provincesPath, "provinces", "", "override path to map/provinces.bmp")
+	flag.StringVar(&terrainPath, "terrain", "", "override path to map/terrain.bmp")
+	flag.StringVar(&terrainCategoriesPath, "terraincategories", "", "override path to common/terrain folder")
+	flag.StringVar(&heightmapPath, "heightmap", "", "override path to map/heightmap.bmp")
+	flag.StringVar(&statesPath, "states", "", "override path to history/states folder")
+	flag.StringVar(&strategicRegionPath, "strategicregions", "", "override path to map/strategicregions folder")
+	flag.StringVar(&stateCategoryPath, "statecategories", "", "override path to common/state_category folder")
+	flag.StringVar(&countryTagsPath, "countrytags", "", "override path to common/country_tags folder")
+	flag.StringVar(&countriesPath, "countries", "", "override path to common/countries folder")
+	flag.StringVar(&localisationPath, "localisation", "", "override path to localisation folder")
+	flag.StringVar(&language, "language", "english", "localisation language for state and strategic region names")
+	flag.Var(&startDate, "date", "start date the state history is evaluated at")
+	flag.StringVar(&centerMethod, "center", "pole", "center point method, \"pole\" for the point farthest from the border or \"mean\" for the average pixel")
+	flag.StringVar(&fontPath, "font", "smallest_pixel-7.ttf", "path to the font used for map labels")
+	flag.Usage = func() {
+		w := flag.CommandLine.Output()
+		fmt.Fprintf(w, "Usage: %s [flags] <group> <command> [command flags] [[<group>] <command> [command flags]...]\n\nFlags:\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+		fmt.Fprintf(w, "\nCommands:\n")
+		printCommands(w, commands)
+	}
+	err := flag.CommandLine.Parse(args)
+	if err != nil {
+		return nil, err
+	}
 
-	// // Generate infrastructure map.
-	// err = generateSmallProvincesMap(32)
-	// if err != nil {
-	// 	panic(err)
-	// }
+	selected, err := parseCommands(flag.Args())
+	if err != nil {
+		return nil, err
+	}
+	// Broken input files are only tolerated if nothing but validation was asked for.
+	lenientParsing = true
+	for _, c := range selected {
+		lenientParsing = lenientParsing && c.Lenient
+	}
+	if centerMethod != "pole" && centerMethod != "mean" {
+		return nil, fmt.Errorf("unknown center point method: %v", centerMethod)
+	}
+	if gamePath == "" && len(modPaths) == 0 {
+		return nil, errors.New("either -game or -mod must be set")
+	}
+	gameFS, err = newModFileSystem(gamePath, modPaths)
+	if err != nil {
+		return nil, err
+	}
 
-	// // Generate color shuffled province map.
-	// err = generateColorShuffledProvinceMap()
-	// if err != nil {
-	// 	panic(err)
-	// }
+	definitionsPath = resolveInputPath(definitionsPath, "map/definition.csv")
+	adjacenciesPath = resolveInputPath(adjacenciesPath, "map/adjacencies.csv")
+	adjacencyRulesPath = resolveInputPath(adjacencyRulesPath, "map/adjacency_rules.txt")
+	provincesPath = resolveInputPath(provincesPath, "map/provinces.bmp")
+	terrainPath = resolveInputPath(terrainPath, "map/terrain.bmp")
+	heightmapPath = resolveInputPath(heightmapPath, "map/heightmap.bmp")
 
-	// // Generate state ID map.
-	// err = generateProvinceContinentValues("continents.png")
-	// if err != nil {
-	// 	panic(err)
-	// }
+	return selected, os.MkdirAll(filepath.FromSlash(outputPath), 0775)
+}
 
-	// // Generate impassable terrain map.
-	// err = generateImpassableMap()
-	// if err != nil {
-	// 	panic(err)
-	// }
+// ResolveInputPath returns path if it was set explicitly.
+// Otherwise it returns the effective location of rel
+// in the game and mod folders.
+func resolveInputPath(path, rel string) string {
+	if path != "" {
+		return path
+	}
+	p, err := gameFS.Path(rel)
+	if err != nil {
+		// Let the missing file be reported when it is opened.
+		l := gameFS.Layers[len(gameFS.Layers)-1]
+		return filepath.ToSlash(filepath.Join(l.Path, rel))
+	}
+	return filepath.ToSlash(p)
+}
 
-	// Print out elapsed time.
-	elapsedTime := time.Since(startTime)
-	fmt.Printf("Elapsed time: %s\n", elapsedTime)
+// GlobInputFiles returns files matching pattern in path if it was set explicitly.
+// Otherwise it returns the effective set of files in the rel folder
+// merged across the game and mod folders.
+func globInputFiles(path, rel, pattern string) ([]string, error) {
+	if path != "" {
+		return filepath.Glob(filepath.Join(filepath.FromSlash(path), pattern))
+	}
+	return gameFS.Glob(rel, pattern)
 }
 
 // ReadLines reads a whole file
@@ -276,10 +349,47 @@ func parseDefinitions() error {
 		return err
 	}
 
-	for _, s := range definitions {
-		province, err := parseDefinitionsProvince(s)
+	prevID := -1
+	for i, s := range definitions {
+		// Skip commented and empty lines.
+		if strings.HasPrefix(s, "#") || len(s) == 0 {
+			continue
+		}
+
+		pos := filePos{Path: definitionsPath, Line: i + 1}
+		province, err := parseDefinitionsProvince(pos, s)
 		if err != nil {
-			return err
+			err = lenientError("definitions", err)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Duplicates and gaps are reported by "validate definitions",
+		// other runs print a warning for skipped duplicates.
+		// Later definitions of an ID or a color are skipped, so both maps
+		// hold the same provinces and every pixel belongs to a province in provincesIDMap.
+		if p, ok := provincesIDMap[province.ID]; ok {
+			issue := validationIssue{Check: "definitions", Pos: pos, IDs: []int{province.ID}, Message: fmt.Sprintf("duplicate province ID %v, first defined on line %v, the entry is skipped", province.ID, p.Line)}
+			addValidationIssue(issue)
+			if !lenientParsing {
+				fmt.Printf("%s: %v\n", time.Since(startTime), issue)
+			}
+			continue
+		}
+		if province.ID != prevID+1 {
+			addValidationIssue(validationIssue{Check: "definitions", Pos: pos, IDs: []int{province.ID}, Message: fmt.Sprintf("province ID %v is not sequential, expected %v", province.ID, prevID+1)})
+		}
+		prevID = province.ID
+
+		if p, ok := provincesRGBMap[province.RGB]; ok {
+			issue := validationIssue{Check: "definitions", Pos: pos, IDs: []int{p.ID, province.ID}, Message: fmt.Sprintf("duplicate color %v;%v;%v of province %v, first used by province %v, the entry is skipped", province.RGB.R, province.RGB.G, province.RGB.B, province.ID, p.ID)}
+			addValidationIssue(issue)
+			if !lenientParsing {
+				fmt.Printf("%s: %v\n", time.Since(startTime), issue)
+			}
+			continue
 		}
 		provincesIDMap[province.ID] = &province
 		provincesRGBMap[province.RGB] = &province
@@ -287,38 +397,35 @@ func parseDefinitions() error {
 	return nil
 }
 
-func parseDefinitionsProvince(s string) (p Province, err error) {
+func parseDefinitionsProvince(pos filePos, s string) (p Province, err error) {
 	pStrings := strings.Split(s, ";")
 	if len(pStrings) != 8 {
-		return p, errors.New("\"" + definitionsPath + "\": " + s + ": must contain 8 fields")
+		return p, newFileError(pos, "%q: must contain 8 fields", s)
 	}
 
+	p.Line = pos.Line
 	p.ID, err = strconv.Atoi(pStrings[0])
 	if err != nil {
-		return p, err
-	}
-	r, err := strconv.Atoi(pStrings[1])
-	if err != nil {
-		return p, err
-	}
-	g, err := strconv.Atoi(pStrings[2])
-	if err != nil {
-		return p, err
+		return p, newFileError(pos, "invalid province ID %q", pStrings[0])
 	}
-	b, err := strconv.Atoi(pStrings[3])
-	if err != nil {
-		return p, err
+	var rgb [3]uint8
+	for i, name := range []string{"red", "green", "blue"} {
+		v, err := strconv.ParseUint(pStrings[i+1], 10, 8)
+		if err != nil {
+			return p, newFileError(pos, "invalid %v value %q", name, pStrings[i+1])
+		}
+		rgb[i] = uint8(v)
 	}
-	p.RGB = color.RGBA{uint8(r), uint8(g), uint8(b), 255}
+	p.RGB = color.RGBA{rgb[0], rgb[1], rgb[2], 255}
 	p.Type = pStrings[4]
 	p.IsCoastal, err = strconv.ParseBool(pStrings[5])
 	if err != nil {
-		return p, err
+		return p, newFileError(pos, "invalid coastal value %q", pStrings[5])
 	}
 	p.Terrain = pStrings[6]
 	p.Continent, err = strconv.Atoi(pStrings[7])
 	if err != nil {
-		return p, err
+		return p, newFileError(pos, "invalid continent %q", pStrings[7])
 	}
 	p.PixelCoordsMap = make(map[image.Point]bool)
 	p.AdjacentTo = make(map[int]*Province)
@@ -328,55 +435,6 @@ func parseDefinitionsProvince(s string) (p Province, err error) {
 	return p, nil
 }
 
-func parseAdjacencies() error {
-	fmt.Printf("%s: Parsing adjacencies.csv...\n", time.Since(startTime))
-	adjacencies, err := readLines(filepath.FromSlash(adjacenciesPath))
-	if err != nil {
-		return err
-	}
-	// Skip first and last lines.
-	for _, s := range adjacencies[1 : len(adjacencies)-1] {
-		err := parseAdjacenciesState(s)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func parseAdjacenciesState(s string) error {
-	// Skip commented and empty lines.
-	if strings.HasPrefix(s, "#") || len(s) == 0 {
-		return nil
-	}
-
-	a := strings.Split(s, ";")
-	if len(a) != 10 {
-		return errors.New("\"" + adjacenciesPath + "\": " + s + ": must contain 10 fields")
-	}
-
-	id1, err := strconv.Atoi(a[0])
-	if err != nil {
-		return err
-	}
-	id2, err := strconv.Atoi(a[1])
-	if err != nil {
-		return err
-	}
-
-	if a[2] == "sea" || a[2] == "" {
-		provincesIDMap[id1].ConnectedTo[id2] = provincesIDMap[id2]
-		provincesIDMap[id2].ConnectedTo[id1] = provincesIDMap[id1]
-	}
-
-	if a[2] == "impassable" {
-		provincesIDMap[id1].ImpassableTo[id2] = provincesIDMap[id2]
-		provincesIDMap[id2].ImpassableTo[id1] = provincesIDMap[id1]
-	}
-
-	return nil
-}
-
 func parseProvinces() error {
 	fmt.Printf("%s: Parsing provinces.bmp...\n", time.Since(startTime))
 	provincesFile, err := os.Open(filepath.FromSlash(provincesPath))
@@ -391,146 +449,65 @@ func parseProvinces() error {
 
 	provincesImageSize.Max = image.Point{provincesImage.Bounds().Max.X, provincesImage.Bounds().Max.Y}
 
+	// Colors without a definition and their pixels.
+	var undefinedColors []color.RGBA
+	undefinedPixels := make(map[color.RGBA][]image.Point)
+
 	// Parse each pixel in scanline order.
 	for y := 0; y < provincesImage.Bounds().Max.Y; y++ {
 		for x := 0; x < provincesImage.Bounds().Max.X; x++ {
-			var e, s color.Color
-
 			// Get the color of the current pixel.
-			c := provincesImage.At(x, y)
-			r, g, b, a := c.RGBA()
-			c = color.RGBA{uint8(r), uint8(g), uint8(b), uint8(a)}
+			c := rgbaAt(provincesImage, x, y)
+			prov := provincesRGBMap[c]
+			if prov == nil {
+				if _, ok := undefinedPixels[c]; !ok {
+					undefinedColors = append(undefinedColors, c)
+				}
+				undefinedPixels[c] = append(undefinedPixels[c], image.Point{x, y})
+				continue
+			}
 
 			// Add pixel coordinates to the province that has this RGB value.
-			provincesRGBMap[c].PixelCoordsMap[image.Point{x, y}] = true
-			provincesRGBMap[c].PixelCoords = append(provincesRGBMap[c].PixelCoords, image.Point{x, y})
+			prov.PixelCoordsMap[image.Point{x, y}] = true
+			prov.PixelCoords = append(prov.PixelCoords, image.Point{x, y})
 
-			// Find out the color of the adjacent right and bottom pixels.
+			// If the color of the adjacent right and bottom pixels is different
+			// then this two provinces are adjacent.
 			if x < provincesImage.Bounds().Max.X-1 {
-				e = provincesImage.At(x+1, y)
-				r, g, b, a := e.RGBA()
-				e = color.RGBA{uint8(r), uint8(g), uint8(b), uint8(a)}
+				e := provincesRGBMap[rgbaAt(provincesImage, x+1, y)]
+				if e != nil && e != prov {
+					prov.AdjacentTo[e.ID] = e
+					e.AdjacentTo[prov.ID] = prov
+				}
 			}
 			if y < provincesImage.Bounds().Max.Y-1 {
-				s = provincesImage.At(x, y+1)
-				r, g, b, a := s.RGBA()
-				s = color.RGBA{uint8(r), uint8(g), uint8(b), uint8(a)}
-			}
-
-			// If color is different then this two provinces are adjacent.
-			if (c != e) && (e != nil) {
-				provincesRGBMap[c].AdjacentTo[provincesRGBMap[e].ID] = provincesRGBMap[e]
-				provincesRGBMap[e].AdjacentTo[provincesRGBMap[c].ID] = provincesRGBMap[c]
-			}
-			if (c != s) && (s != nil) {
-				provincesRGBMap[c].AdjacentTo[provincesRGBMap[s].ID] = provincesRGBMap[s]
-				provincesRGBMap[s].AdjacentTo[provincesRGBMap[c].ID] = provincesRGBMap[c]
+				s := provincesRGBMap[rgbaAt(provincesImage, x, y+1)]
+				if s != nil && s != prov {
+					prov.AdjacentTo[s.ID] = s
+					s.AdjacentTo[prov.ID] = prov
+				}
 			}
 		}
 	}
-	return nil
-}
-
-func findProvincesCenterPoints() {
-	fmt.Printf("%s: Calculating provinces center point coordinates...\n", time.Since(startTime))
-	for _, p := range provincesIDMap {
-		p.CenterPoint = findCenterPoint(p.PixelCoords)
-	}
-}
-
-func findCenterPoint(coords []image.Point) image.Point {
-	// Fast centerpoint calculation.
-	x := 0
-	y := 0
 
-	for _, c := range coords {
-		x += c.X
-		y += c.Y
-	}
-
-	return image.Point{int(math.Round(float64(x) / float64(len(coords)))), int(math.Round(float64(y) / float64(len(coords))))}
-
-	// // Long largest rects centerpoint calculation.
-	// l := math.MaxInt64
-	// r := math.MinInt64
-	// t := math.MaxInt64
-	// b := math.MinInt64
-	// for _, c := range coords {
-	// 	if c.X < l {
-	// 		l = c.X
-	// 	}
-	// 	if c.X > r {
-	// 		r = c.X
-	// 	}
-	// 	if c.Y < t {
-	// 		t = c.Y
-	// 	}
-	// 	if c.Y > b {
-	// 		b = c.Y
-	// 	}
-	// }
-
-	// maxRectSize := -1
-	// var maxRect image.Rectangle
-	// line := make([]int, r-l+1)
-	// for y := t; y <= b; y++ {
-	// 	i := 0
-	// 	for x := l; x <= r; x++ {
-	// 		if containsPoint(coords, image.Point{x, y}) {
-	// 			line[i]++
-	// 		} else {
-	// 			line[i] = 0
-	// 		}
-	// 		i++
-	// 	}
-	// 	// fmt.Println(line)
-
-	// 	rectSize, xStart, xEnd, yStart := findLargestRectangle(line)
-	// 	if maxRectSize < rectSize {
-	// 		maxRectSize = rectSize
-	// 		maxRect.Min = image.Point{l + xStart, y - yStart + 1}
-	// 		maxRect.Max = image.Point{l + xEnd - 1, y - 1}
-	// 	}
-	// }
-	// // fmt.Println("> ", l, t, r, b, maxRectSize, maxRect, image.Point{int(math.Round(float64(maxRect.Min.X+maxRect.Max.X) / 2)), int(math.Round(float64(maxRect.Min.Y+maxRect.Max.Y) / 2))})
-
-	// return image.Point{int(math.Round(float64(maxRect.Min.X+maxRect.Max.X) / 2)), int(math.Round(float64(maxRect.Min.Y+maxRect.Max.Y) / 2))}
-}
-
-func findLargestRectangle(hist []int) (int, int, int, int) {
-	var h, pos, tempH, tempPos int
-	var xStart, xEnd, yStart int
-	var hStack, posStack []int
-	maxSize := -1
-	tempSize := -1
-
-	for pos = 0; pos < len(hist); pos++ {
-		h = hist[pos]
-		if len(hStack) == 0 || h > hStack[len(hStack)-1] {
-			hStack = append(hStack, h)
-			posStack = append(posStack, pos)
-		} else if h < hStack[len(hStack)-1] {
-			for len(hStack) > 0 && h < hStack[len(hStack)-1] {
-				hStack, posStack, tempH, tempPos, tempSize = popStack(hStack, posStack, pos, maxSize)
-				if maxSize < tempSize {
-					maxSize = tempSize
-					xStart = tempPos
-					xEnd = pos
-					yStart = tempH
-				}
-			}
-			hStack = append(hStack, h)
-			posStack = append(posStack, tempPos)
+	for _, c := range undefinedColors {
+		err := reportIssue(validationIssue{
+			Check:   "definitions",
+			Pos:     filePos{Path: provincesPath},
+			Points:  undefinedPixels[c],
+			Message: fmt.Sprintf("color %v;%v;%v has no definition (%v pixels)", c.R, c.G, c.B, len(undefinedPixels[c])),
+		})
+		if err != nil {
+			return err
 		}
 	}
-	return maxSize, xStart, xEnd, yStart
+	return nil
 }
 
-func popStack(hStack, posStack []int, pos, maxSize int) ([]int, []int, int, int, int) {
-	tempH, hStack := hStack[len(hStack)-1], hStack[:len(hStack)-1]
-	tempPos, posStack := posStack[len(posStack)-1], posStack[:len(posStack)-1]
-	tempSize := tempH * (pos - tempPos)
-	return hStack, posStack, tempH, tempPos, tempSize
+// RgbaAt returns the opaque color of the pixel at x, y.
+func rgbaAt(img image.Image, x, y int) color.RGBA {
+	r, g, b, a := img.At(x, y).RGBA()
+	return color.RGBA{uint8(r), uint8(g), uint8(b), uint8(a)}
 }
 
 func maxInt(x, y int) int {
@@ -542,66 +519,126 @@ func maxInt(x, y int) int {
 
 func parseStateFiles() error {
 	fmt.Printf("%s: Parsing state files...\n", time.Since(startTime))
-	stateFiles, err := filepath.Glob(filepath.FromSlash(statesPath) + string(os.PathSeparator) + "*.txt")
+	stateFiles, err := globInputFiles(statesPath, "history/states", "*.txt")
 	if err != nil {
 		return err
 	}
-	for _, s := range stateFiles {
-		state, err := parseState(s)
+	for _, path := range stateFiles {
+		// Broken files and entries are skipped in lenient mode.
+		root, err := parseScriptFile(path)
 		if err != nil {
-			return err
+			err = lenientError("assignments", err)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		stateNodes := root.FindAll("state")
+		if len(stateNodes) == 0 {
+			err = lenientError("assignments", newFileError(root.Pos, "no state found"))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		for _, n := range stateNodes {
+			state, err := parseState(n)
+			if err != nil {
+				err = lenientError("assignments", err)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			// The first definition of an ID is kept.
+			if prev, ok := statesMap[state.ID]; ok {
+				err = reportIssue(validationIssue{Check: "assignments", Pos: state.Pos, Message: fmt.Sprintf("duplicate state ID %v, first defined at %v", state.ID, prev.Pos)})
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			statesMap[state.ID] = &state
 		}
-		statesMap[state.ID] = &state
 	}
 	return nil
 }
 
-func parseState(path string) (state State, err error) {
-	b, err := ioutil.ReadFile(path)
-	if err != nil {
-		return state, err
+func parseState(n *scriptNode) (state State, err error) {
+	if !n.IsBlock {
+		return state, newFileError(n.Pos, "state: expected block")
 	}
-	s := strings.Replace(string(b), "\r\n", "\n", -1)
 
-	state.ID, err = strconv.Atoi(rStateID.FindStringSubmatch(s)[1])
+	id := n.Find("id")
+	if id == nil {
+		return state, newFileError(n.Pos, "state has no id")
+	}
+	state.ID, err = id.Int()
 	if err != nil {
 		return state, err
 	}
+	state.Pos = n.Pos
 
-	r := rStateName.FindStringSubmatch(s)
-	if r != nil {
-		state.Name = r[1]
+	if v := n.Find("name"); v != nil {
+		state.Name = v.Value
 	}
 
-	r = rStateManpower.FindStringSubmatch(s)
-	if r != nil {
-		state.Manpower, err = strconv.Atoi(r[1])
+	if v := n.Find("manpower"); v != nil {
+		state.Manpower, err = v.Int()
 		if err != nil {
 			return state, err
 		}
 	}
 
-	r = rStateInfrastructure.FindStringSubmatch(s)
-	if r != nil {
-		state.Infrastructure, err = strconv.Atoi(r[1])
+	// The category has to be known before the history adds extra building slots to it.
+	if v := n.Find("state_category"); v != nil {
+		state.Category = v.Value
+		// A state with an unknown category gets no category building slots.
+		_, ok := stateCategoriesMap[state.Category]
+		if !ok && len(stateCategoriesMap) > 0 {
+			issue := validationIssue{Check: "assignments", Pos: v.Pos, Message: fmt.Sprintf("unknown state_category %q of state %v", state.Category, state.ID)}
+			addValidationIssue(issue)
+			// Validate runs list the issue in the report instead.
+			if !lenientParsing {
+				fmt.Printf("%s: %v, the state has no category building slots\n", time.Since(startTime), issue)
+			}
+		}
+	}
+
+	for _, history := range n.FindAll("history") {
+		err = collectStateHistory(&state, history)
 		if err != nil {
 			return state, err
 		}
 	}
-
-	r = rStateImpassable.FindStringSubmatch(s)
-	if r != nil {
-		state.IsImpassable = true
+	err = evaluateStateHistory(&state, startDate)
+	if err != nil {
+		return state, err
 	}
 
-	state.Provinces = make(map[int]*Province)
-	provinces := strings.Split(strings.TrimSpace(rSpace.ReplaceAllString(rStateProvinces.FindStringSubmatch(s)[1], " ")), " ")
-	for _, p := range provinces {
-		pID, err := strconv.Atoi(p)
+	if v := n.Find("impassable"); v != nil {
+		state.IsImpassable, err = v.Bool()
 		if err != nil {
 			return state, err
 		}
-		state.Provinces[pID] = provincesIDMap[pID]
+	}
+
+	state.Resources = make(map[string]float64)
+	for _, resources := range n.FindAll("resources") {
+		if !resources.IsBlock {
+			return state, newFileError(resources.Pos, "resources: expected block")
+		}
+		for _, r := range resources.Children {
+			state.Resources[strings.ToLower(r.Key)], err = r.Float()
+			if err != nil {
+				return state, err
+			}
+		}
+	}
+
+	state.Provinces, err = parseProvinceList(n)
+	if err != nil {
+		return state, err
 	}
 	state.Continent = -1
 	state.PixelCoordsMap = make(map[image.Point]bool)
@@ -613,6 +650,33 @@ func parseState(path string) (state State, err error) {
 	return state, nil
 }
 
+// ParseProvinceList returns the provinces of every "provinces" list in n.
+// Unknown province IDs are skipped in lenient mode.
+func parseProvinceList(n *scriptNode) (map[int]*Province, error) {
+	provinces := make(map[int]*Province)
+	for _, list := range n.FindAll("provinces") {
+		if !list.IsBlock {
+			return nil, newFileError(list.Pos, "%v: expected block, found %q", list.Key, list.Value)
+		}
+		for _, v := range list.Values() {
+			pID, err := v.Int()
+			if err != nil {
+				return nil, err
+			}
+			p, ok := provincesIDMap[pID]
+			if !ok {
+				err = reportIssue(validationIssue{Check: "assignments", Pos: v.Pos, Message: fmt.Sprintf("%v references unknown province %v", n.Key, pID)})
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+			provinces[pID] = p
+		}
+	}
+	return provinces, nil
+}
+
 func parseStatesProvinces() {
 	fmt.Printf("%s: Parsing provinces in each state...\n", time.Since(startTime))
 	for _, s1 := range statesMap {
@@ -651,6 +715,17 @@ func parseStatesProvinces() {
 				}
 			}
 
+			// Add adjacencies.csv entries of the province to the state.
+			for _, a := range p1.Adjacencies {
+				if !containsAdjacency(s1.Adjacencies, a) {
+					s1.Adjacencies = append(s1.Adjacencies, a)
+				}
+			}
+
+			// Add state history to the province.
+			p1.VictoryPoints = s1.VictoryPoints[p1.ID]
+			p1.Buildings = s1.ProvinceBuildings[p1.ID]
+
 			// Add state to the province.
 			p1.State = s1
 		}
@@ -661,6 +736,9 @@ func parseStatesProvinces() {
 		// fmt.Printf("%s: Calculating states center point coordinates...\n", time.Since(startTime))
 		s1.CenterPoint = findCenterPoint(s1.PixelCoords)
 
+		// Keep adjacencies in adjacencies.csv order.
+		sort.Slice(s1.Adjacencies, func(i, j int) bool { return s1.Adjacencies[i].Line < s1.Adjacencies[j].Line })
+
 		// If state has provinces with non-empty impassableTo field.
 		// Check if all provinces adjacent to another state are impassable to it.
 		// If that's the case, then add this state to impassableTo filed of the first sate.
@@ -714,48 +792,78 @@ func distance(c1, c2 image.Point) int {
 
 func parseStrategicRegionFiles() error {
 	fmt.Printf("%s: Parsing strategic region files...\n", time.Since(startTime))
-	strategicRegionFiles, err := filepath.Glob(filepath.FromSlash(strategicRegionPath) + string(os.PathSeparator) + "*.txt")
+	strategicRegionFiles, err := globInputFiles(strategicRegionPath, "map/strategicregions", "*.txt")
 	if err != nil {
 		return err
 	}
-	for _, r := range strategicRegionFiles {
-		strategicRegion, err := parseStrategicRegion(r)
+	for _, path := range strategicRegionFiles {
+		// Broken files and entries are skipped in lenient mode.
+		root, err := parseScriptFile(path)
 		if err != nil {
-			return err
+			err = lenientError("assignments", err)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		regionNodes := root.FindAll("strategic_region")
+		if len(regionNodes) == 0 {
+			err = lenientError("assignments", newFileError(root.Pos, "no strategic_region found"))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		for _, n := range regionNodes {
+			strategicRegion, err := parseStrategicRegion(n)
+			if err != nil {
+				err = lenientError("assignments", err)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			// The first definition of an ID is kept.
+			if prev, ok := strategicRegionMap[strategicRegion.ID]; ok {
+				err = reportIssue(validationIssue{Check: "assignments", Pos: strategicRegion.Pos, Message: fmt.Sprintf("duplicate strategic region ID %v, first defined at %v", strategicRegion.ID, prev.Pos)})
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			strategicRegionMap[strategicRegion.ID] = &strategicRegion
 		}
-		strategicRegionMap[strategicRegion.ID] = &strategicRegion
 	}
 	return nil
 }
 
-func parseStrategicRegion(path string) (strategicRegion StrategicRegion, err error) {
-	b, err := ioutil.ReadFile(path)
-	if err != nil {
-		return strategicRegion, err
+func parseStrategicRegion(n *scriptNode) (strategicRegion StrategicRegion, err error) {
+	if !n.IsBlock {
+		return strategicRegion, newFileError(n.Pos, "strategic_region: expected block")
 	}
-	s := strings.Replace(string(b), "\r\n", "\n", -1)
 
-	strategicRegion.ID, err = strconv.Atoi(rStateID.FindStringSubmatch(s)[1])
+	id := n.Find("id")
+	if id == nil {
+		return strategicRegion, newFileError(n.Pos, "strategic_region has no id")
+	}
+	strategicRegion.ID, err = id.Int()
 	if err != nil {
 		return strategicRegion, err
 	}
+	strategicRegion.Pos = n.Pos
 
-	r := rStateName.FindStringSubmatch(s)
-	if r != nil {
-		strategicRegion.Name = r[1]
+	if v := n.Find("name"); v != nil {
+		strategicRegion.Name = v.Value
 	}
 
-	strategicRegion.Provinces = make(map[int]*Province)
-	provinces := strings.Split(strings.TrimSpace(rSpace.ReplaceAllString(rStateProvinces.FindStringSubmatch(s)[1], " ")), " ")
-	for _, p := range provinces {
-		pID, err := strconv.Atoi(p)
-		if err != nil {
-			return strategicRegion, err
-		}
-		strategicRegion.Provinces[pID] = provincesIDMap[pID]
+	strategicRegion.Provinces, err = parseProvinceList(n)
+	if err != nil {
+		return strategicRegion, err
 	}
 
 	strategicRegion.PixelCoordsMap = make(map[image.Point]bool)
+	strategicRegion.AdjacentTo = make(map[int]*StrategicRegion)
+	strategicRegion.ConnectedTo = make(map[int]*StrategicRegion)
 
 	return strategicRegion, nil
 }
@@ -773,107 +881,64 @@ func parseStrategicRegionsProvinces() {
 			// Add strategic region to the province.
 			p.StrategicRegion = r
 		}
-		// // Find the center point of the strategic region.
-		// // fmt.Printf("%s: Calculating strategic regions center point coordinates...\n", time.Since(startTime))
-		// r.CenterPoint = findCenterPoint(r.PixelCoords)
+		// Find the center point of the strategic region.
+		r.CenterPoint = findCenterPoint(r.PixelCoords)
 	}
-}
-
-func saveGeoData() error {
-	fmt.Printf("%s: Writing the output file...\n", time.Since(startTime))
-	// Create new file.
-	f, err := os.Create("hoi4geoparser_data.txt")
-	if err != nil {
-		return err
-	}
-	defer f.Close()
 
-	// Write on_actions header into the output file.
-	_, err = f.WriteString("# Autogenerated by hoi4geoparser. Do not mess with the data.\n# evil_c0okie (https://github.com/malashin/hoi4geoparser)\n\non_actions = {\n\ton_startup = {\n\t\teffect = {\n")
-	if err != nil {
-		return err
-	}
-
-	// Sort the state ids.
-	statesIDs := sortedKeySliceFromStateMap(statesMap)
-	// Iterate over all states in ID sorted order.
-	for _, sID := range statesIDs {
-		// if len(statesMap[sID].ConnectedTo) == 0 && len(statesMap[sID].ImpassableTo) == 0 {
-		// 	continue
-		// }
-
-		if len(statesMap[sID].ImpassableTo) == 0 && !statesMap[sID].IsImpassable {
-			continue
-		}
-
-		// Write the state id into the output file.
-		_, err = f.WriteString("\t\t\t" + strconv.Itoa(sID) + " = {\n")
-		if err != nil {
-			return err
-		}
-
-		// if len(statesMap[sID].ConnectedTo) > 0 {
-		// 	// Sort the map.
-		// 	statesConnectedToIDs := sortedKeySliceFromStateMap(statesMap[sID].ConnectedTo)
-		// 	// Iterate over all states from ConnectedTo map in ID sorted order.
-		// 	for _, cID := range statesConnectedToIDs {
-		// 		// Write the connected_to@STATE variables.
-		// 		_, err = f.WriteString("\t\t\t\tset_variable = { connected_to@" + strconv.Itoa(cID) + " = 1 }\n")
-		// 		if err != nil {
-		// 			return err
-		// 		}
-		// 	}
-		// }
-
-		if len(statesMap[sID].ImpassableTo) > 0 {
-			// Sort the map.
-			statesImpassableToIDs := sortedKeySliceFromStateMap(statesMap[sID].ImpassableTo)
-			// Iterate over all states from ImpassableTo map in ID sorted order.
-			for _, aID := range statesImpassableToIDs {
-				// Write the impassable_to@STATE variables.
-				_, err = f.WriteString("\t\t\t\tset_variable = { impassable_to@" + strconv.Itoa(aID) + " = 1 }\n")
-				if err != nil {
-					return err
+	// Fill up adjacentTo and connectedTo fields in all strategic regions
+	// based on the strategic regions of adjacent provinces.
+	// Regions are connected where a land province borders a sea province
+	// and where adjacencies.csv connects their provinces.
+	for _, r := range strategicRegionMap {
+		r.IsSea = len(r.Provinces) > 0
+		for _, p := range r.Provinces {
+			if p.Type != "sea" {
+				r.IsSea = false
+			}
+			for _, a := range p.AdjacentTo {
+				if a.StrategicRegion != nil && a.StrategicRegion != r {
+					r.AdjacentTo[a.StrategicRegion.ID] = a.StrategicRegion
+					if a.Type != p.Type {
+						r.ConnectedTo[a.StrategicRegion.ID] = a.StrategicRegion
+					}
 				}
 			}
-		}
-
-		if statesMap[sID].IsImpassable {
-			// Write the is_impassable state flag.
-			_, err = f.WriteString("\t\t\t\tset_state_flag = is_impassable\n")
-			if err != nil {
-				return err
+			for _, c := range p.ConnectedTo {
+				if c.StrategicRegion != nil && c.StrategicRegion != r {
+					r.ConnectedTo[c.StrategicRegion.ID] = c.StrategicRegion
+				}
 			}
 		}
+	}
+}
 
-		// // Sort the map.
-		// statesDistanceToIDs := sortedKeySliceFromIntMap(statesMap[sID].DistanceTo)
-		// // Iterate over all states from DistanceTO map in ID sorted order.
-		// for _, dID := range statesDistanceToIDs {
-		// 	// Write the distance_to@STATE variables.
-		// 	_, err = f.WriteString("\t\t\t\tset_variable = { distance_to@" + strconv.Itoa(dID) + " = " + strconv.Itoa(statesMap[sID].DistanceTo[dID]) + " }\n")
-		// 	if err != nil {
-		// 		return err
-		// 	}
-		// }
+// GeoDataFileName is the file written by "export geodata".
+const geoDataFileName = "hoi4geoparser_data.txt"
 
-		// Write the state closing brackets into the output file.
-		_, err = f.WriteString("\t\t\t}\n")
-		if err != nil {
-			return err
-		}
-	}
+func saveGeoData() error {
+	return saveScript("geodata", geoDataFileName, nil)
+}
 
-	// Write the on_startup and effect closing brackets into the output file.
-	_, err = f.WriteString("\t\t}\n\t}\n}\n")
-	if err != nil {
-		return err
+func sortedKeySliceFromStateMap(m map[int]*State) []int {
+	slice := []int{}
+	for k := range m {
+		slice = append(slice, k)
 	}
+	sort.Ints(slice)
+	return slice
+}
 
-	return nil
+func sortedKeySliceFromProvinceMap(m map[int]*Province) []int {
+	slice := []int{}
+	for k := range m {
+		slice = append(slice, k)
+	}
+	sort.Ints(slice)
+	return slice
 }
 
-func sortedKeySliceFromStateMap(m map[int]*State) (slice []int) {
+func sortedKeySliceFromStrategicRegionMap(m map[int]*StrategicRegion) []int {
+	slice := []int{}
 	for k := range m {
 		slice = append(slice, k)
 	}
@@ -889,6 +954,14 @@ func sortedKeySliceFromIntMap(m map[int]int) (slice []int) {
 	return slice
 }
 
+func sortedKeySliceFromBoolMap(m map[int]bool) (slice []int) {
+	for k := range m {
+		slice = append(slice, k)
+	}
+	sort.Ints(slice)
+	return slice
+}
+
 func generateRandomLightColor() color.RGBA {
 	max := 255
 	min := 128
@@ -1013,7 +1086,7 @@ func generateSateMap() error {
 	}
 
 	// Save image as PNG.
-	out, err := os.Create("./state_map.png")
+	out, err := os.Create(filepath.Join(outputPath, "state_map.png"))
 	if err != nil {
 		return err
 	}
@@ -1050,7 +1123,7 @@ func generateColoredSateMap() error {
 	}
 
 	// Save image as PNG.
-	out, err := os.Create("./state_map_colored.png")
+	out, err := os.Create(filepath.Join(outputPath, "state_map_colored.png"))
 	if err != nil {
 		return err
 	}
@@ -1063,7 +1136,24 @@ func generateColoredSateMap() error {
 }
 
 func generateSateIDMap() error {
-	fmt.Printf("%s: Generating state ID map...\n", time.Since(startTime))
+	return generateStateLabelMap("state ID", "state_map_with_ids.png", func(s *State) string {
+		return strconv.Itoa(s.ID)
+	})
+}
+
+func generateSateNameMap() error {
+	err := localiseNames()
+	if err != nil {
+		return err
+	}
+	return generateStateLabelMap("state name", "state_map_with_names.png", func(s *State) string {
+		return s.LocalisedName
+	})
+}
+
+// GenerateStateLabelMap draws the state map with a label in the center of each state.
+func generateStateLabelMap(name, fileName string, label func(s *State) string) error {
+	fmt.Printf("%s: Generating %v map...\n", time.Since(startTime), name)
 
 	// Create empty image and fill it with blue color (water).
 	img := image.NewRGBA(provincesImageSize)
@@ -1131,12 +1221,14 @@ func generateSateIDMap() error {
 		return err
 	}
 
-	//Draw state IDs.
+	//Draw state labels.
 	for _, s := range statesMap {
-		n := strconv.FormatInt(int64(s.ID), 10)
+		n := label(s)
 		offset := 0
 		if n != "" {
-			offset = (len(n)*charWidth - strings.Count(n, "1") + len(n) - 1) / 2
+			// Localised names can contain multi-byte characters.
+			l := utf8.RuneCountInString(n)
+			offset = (l*charWidth - strings.Count(n, "1") + l - 1) / 2
 		}
 		err := addLabel(img, c, s.CenterPoint.X-offset, s.CenterPoint.Y+charHeight/2+1, 10.0, n)
 		if err != nil {
@@ -1145,7 +1237,7 @@ func generateSateIDMap() error {
 	}
 
 	// Save image as PNG.
-	out, err := os.Create("./state_map_with_ids.png")
+	out, err := os.Create(filepath.Join(outputPath, fileName))
 	if err != nil {
 		return err
 	}
@@ -1153,13 +1245,85 @@ func generateSateIDMap() error {
 	if err != nil {
 		return err
 	}
-	fmt.Printf("%s: Saved 'state_map_with_ids.png'\n", time.Since(startTime))
+	fmt.Printf("%s: Saved '%v'\n", time.Since(startTime), fileName)
+	return nil
+}
+
+func generateStrategicRegionIDMap() error {
+	fmt.Printf("%s: Generating strategic region ID map...\n", time.Since(startTime))
+
+	// Create empty image and fill it with blue color (water).
+	img := image.NewRGBA(provincesImageSize)
+	draw.Draw(img, img.Bounds(), &image.Uniform{waterColor}, image.ZP, draw.Src)
+
+	// Draw land province shapes.
+	fillCol := color.RGBA{255, 255, 255, 255}
+	for _, prov := range provincesIDMap {
+		if prov.Type == "land" {
+			for _, p := range prov.PixelCoords {
+				img.Set(p.X, p.Y, fillCol)
+			}
+		}
+	}
+
+	// Draw strategic region borders.
+	strategicRegionBorderColor := color.RGBA{96, 96, 96, 255}
+	for _, r := range strategicRegionMap {
+		for _, p := range r.PixelCoords {
+			_, exists := r.PixelCoordsMap[image.Point{p.X + 1, p.Y}]
+			if !exists {
+				img.Set(p.X+1, p.Y, strategicRegionBorderColor)
+			}
+			_, exists = r.PixelCoordsMap[image.Point{p.X, p.Y + 1}]
+			if !exists {
+				img.Set(p.X, p.Y+1, strategicRegionBorderColor)
+			}
+			_, exists = r.PixelCoordsMap[image.Point{p.X - 1, p.Y}]
+			if !exists {
+				img.Set(p.X, p.Y, strategicRegionBorderColor)
+			}
+			_, exists = r.PixelCoordsMap[image.Point{p.X, p.Y - 1}]
+			if !exists {
+				img.Set(p.X, p.Y, strategicRegionBorderColor)
+			}
+		}
+	}
+
+	// Init font.
+	c, err := initFont(img)
+	if err != nil {
+		return err
+	}
+
+	// Draw strategic region IDs.
+	for _, r := range strategicRegionMap {
+		if len(r.PixelCoords) == 0 {
+			continue
+		}
+		n := strconv.Itoa(r.ID)
+		offset := (len(n)*charWidth - strings.Count(n, "1") + len(n) - 1) / 2
+		err := addLabel(img, c, r.CenterPoint.X-offset, r.CenterPoint.Y+charHeight/2+1, 10.0, n)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Save image as PNG.
+	out, err := os.Create(filepath.Join(outputPath, "strategic_region_map_with_ids.png"))
+	if err != nil {
+		return err
+	}
+	err = png.Encode(out, img)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: Saved 'strategic_region_map_with_ids.png'\n", time.Since(startTime))
 	return nil
 }
 
 func initFont(img *image.RGBA) (*freetype.Context, error) {
 	// Read the font data.
-	fontBytes, err := ioutil.ReadFile("smallest_pixel-7.ttf")
+	fontBytes, err := ioutil.ReadFile(filepath.FromSlash(fontPath))
 	if err != nil {
 		return nil, err
 	}
@@ -1260,7 +1424,7 @@ func generateProvinceMap() error {
 	}
 
 	// Save image as PNG.
-	out, err := os.Create("./province_map.png")
+	out, err := os.Create(filepath.Join(outputPath, "province_map.png"))
 	if err != nil {
 		return err
 	}
@@ -1371,7 +1535,7 @@ func generateProvinceIDMap() error {
 	}
 
 	// Save image as PNG.
-	out, err := os.Create("./province_id_map.png")
+	out, err := os.Create(filepath.Join(outputPath, "province_id_map.png"))
 	if err != nil {
 		return err
 	}
@@ -1384,7 +1548,7 @@ func generateProvinceIDMap() error {
 	return nil
 }
 
-func generateManpowerMap() error {
+func generateManpowerMap(mpMin int) error {
 	fmt.Printf("%s: Generating manpower map...\n", time.Since(startTime))
 
 	// Create empty image and fill it with blue color (water).
@@ -1392,9 +1556,7 @@ func generateManpowerMap() error {
 	draw.Draw(img, img.Bounds(), &image.Uniform{waterColor}, image.ZP, draw.Src)
 
 	// Find highest manpower value in a state.
-	// mpMin := 200000 // Base game
-	// mpMin := 10000 // EaW
-	mpMin := 1000 // OWB
+	// Values below mpMin are drawn with the lowest color.
 	mpMax := 0
 	for _, s := range statesMap {
 		if s.Manpower > mpMax {
@@ -1413,8 +1575,12 @@ func generateManpowerMap() error {
 
 	for _, s := range statesMap {
 		// mp := float64(s.Manpower) / float64(mpMax)
-		mp := linearToLog(math.Max(float64(s.Manpower), float64(mpMin)), logMin, logRange)
-		fillCol := colorFromGradient(mp, gradient)
+		// Every state gets the lowest color if no state has more manpower than mpMin.
+		fillCol := colorLow
+		if logRange > 0 {
+			mp := linearToLog(math.Max(float64(s.Manpower), float64(mpMin)), logMin, logRange)
+			fillCol = colorFromGradient(mp, gradient)
+		}
 		for _, p := range s.PixelCoords {
 			img.Set(p.X, p.Y, fillCol)
 		}
@@ -1472,7 +1638,7 @@ func generateManpowerMap() error {
 	}
 
 	// Save image as PNG.
-	out, err := os.Create("./manpower_map.png")
+	out, err := os.Create(filepath.Join(outputPath, "manpower_map.png"))
 	if err != nil {
 		return err
 	}
@@ -1527,7 +1693,7 @@ func generateSeaProvinceMap() error {
 	}
 
 	// Save image as PNG.
-	out, err := os.Create("./sea_province_map.png")
+	out, err := os.Create(filepath.Join(outputPath, "sea_province_map.png"))
 	if err != nil {
 		return err
 	}
@@ -1579,7 +1745,7 @@ func generateProvinceBasedTerrainMap() error {
 	}
 
 	// Save image as PNG.
-	out, err := os.Create("./province_based_terrain.png")
+	out, err := os.Create(filepath.Join(outputPath, "province_based_terrain.png"))
 	if err != nil {
 		return err
 	}
@@ -1592,7 +1758,7 @@ func generateProvinceBasedTerrainMap() error {
 	return nil
 }
 
-func generateProvinceBasedHeightmapThresholdMap() error {
+func generateProvinceBasedHeightmapThresholdMap(threshold, spread uint8) error {
 	fmt.Printf("%s: Generating province-based heightmap threshold map...\n", time.Since(startTime))
 
 	heightmapFile, err := os.Open(filepath.FromSlash(heightmapPath))
@@ -1625,7 +1791,7 @@ func generateProvinceBasedHeightmapThresholdMap() error {
 			}
 
 			// Color every province higher then that value pink.
-			if heightmapColor.R > 222 {
+			if heightmapColor.R > threshold {
 				for _, pc := range p.PixelCoords {
 					img.Set(pc.X, pc.Y, color.RGBA{255, 0, 255, 255})
 				}
@@ -1646,7 +1812,7 @@ func generateProvinceBasedHeightmapThresholdMap() error {
 					dark = c.R
 				}
 			}
-			if bright-dark > 100 {
+			if bright-dark > spread {
 				for _, pc := range p.PixelCoords {
 					img.Set(pc.X, pc.Y, color.RGBA{255, 255, 0, 255})
 				}
@@ -1655,7 +1821,7 @@ func generateProvinceBasedHeightmapThresholdMap() error {
 	}
 
 	// Save image as PNG.
-	out, err := os.Create("./province_based_heightmap_threshold.png")
+	out, err := os.Create(filepath.Join(outputPath, "province_based_heightmap_threshold.png"))
 	if err != nil {
 		return err
 	}
@@ -1669,18 +1835,70 @@ func generateProvinceBasedHeightmapThresholdMap() error {
 }
 
 func generateInfrastructureMap() error {
-	fmt.Printf("%s: Generating infrastructure map...\n", time.Since(startTime))
+	return generateStateValueMap("infrastructure", "infrastructure_map.png", func(s *State) float64 {
+		return float64(s.Infrastructure)
+	})
+}
+
+func generateBuildingSlotsMap() error {
+	return generateStateValueMap("building slots", "building_slots_map.png", func(s *State) float64 {
+		return float64(s.BuildingSlots)
+	})
+}
+
+// GenerateResourcesMap draws the amount of a single resource in each state,
+// or the sum of all resources if resource is empty.
+func generateResourcesMap(resource string) error {
+	resource, err := stateResourceKey(resource)
+	if err != nil {
+		return err
+	}
+	name := "resources"
+	fileName := "resources_map.png"
+	if resource != "" {
+		name = resource + " resource"
+		fileName = resource + "_resource_map.png"
+	}
+	return generateStateValueMap(name, fileName, func(s *State) float64 {
+		if resource != "" {
+			return s.Resources[resource]
+		}
+		sum := 0.0
+		for _, v := range s.Resources {
+			sum += v
+		}
+		return sum
+	})
+}
+
+// StateResourceKey returns the resource key the way it is stored in State.Resources,
+// which keys are lowercased when parsed. An empty resource stands for all of them.
+func stateResourceKey(resource string) (string, error) {
+	if resource == "" {
+		return "", nil
+	}
+	resource = strings.ToLower(resource)
+	for _, s := range statesMap {
+		if _, ok := s.Resources[resource]; ok {
+			return resource, nil
+		}
+	}
+	return "", fmt.Errorf("no state has resource %q", resource)
+}
+
+// GenerateStateValueMap draws states colored by a value
+// from the lowest to the highest one, with the values as labels.
+func generateStateValueMap(name, fileName string, value func(s *State) float64) error {
+	fmt.Printf("%s: Generating %v map...\n", time.Since(startTime), name)
 
 	// Create empty image and fill it with blue color (water).
 	img := image.NewRGBA(provincesImageSize)
 	draw.Draw(img, img.Bounds(), &image.Uniform{waterColor}, image.ZP, draw.Src)
 
-	// Find highest infrastructure value.
-	iMax := 0
+	// Find highest value.
+	vMax := 0.0
 	for _, s := range statesMap {
-		if s.Infrastructure > iMax {
-			iMax = s.Infrastructure
-		}
+		vMax = math.Max(vMax, value(s))
 	}
 
 	// Draw state shapes.
@@ -1690,8 +1908,11 @@ func generateInfrastructureMap() error {
 	gradient := []color.RGBA{colorLow, colorMid, colorHigh}
 
 	for _, s := range statesMap {
-		i := 100 / float64(iMax) * float64(s.Infrastructure) / 100
-		fillCol := colorFromGradient(i, gradient)
+		v := 0.0
+		if vMax > 0 {
+			v = value(s) / vMax
+		}
+		fillCol := colorFromGradient(v, gradient)
 		for _, p := range s.PixelCoords {
 			img.Set(p.X, p.Y, fillCol)
 		}
@@ -1735,9 +1956,9 @@ func generateInfrastructureMap() error {
 		return err
 	}
 
-	//Draw state infrastructure values.
+	//Draw state values.
 	for _, s := range statesMap {
-		n := strconv.Itoa(s.Infrastructure)
+		n := strconv.FormatFloat(value(s), 'f', -1, 64)
 		offset := 0
 		if n != "" {
 			offset = (len(n)*charWidth - strings.Count(n, "1") + len(n) - 1) / 2
@@ -1749,7 +1970,7 @@ func generateInfrastructureMap() error {
 	}
 
 	// Save image as PNG.
-	out, err := os.Create("./infrastructure_map.png")
+	out, err := os.Create(filepath.Join(outputPath, fileName))
 	if err != nil {
 		return err
 	}
@@ -1757,7 +1978,7 @@ func generateInfrastructureMap() error {
 	if err != nil {
 		return err
 	}
-	fmt.Printf("%s: Saved 'infrastructure_map.png'\n", time.Since(startTime))
+	fmt.Printf("%s: Saved '%v'\n", time.Since(startTime), fileName)
 
 	return nil
 }
@@ -1790,7 +2011,7 @@ func generateSmallProvincesMap(threshold int) error {
 	sort.Ints(smallProvinceList)
 
 	// Create text file.
-	f, err := os.OpenFile("small_provinces_list.txt", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0775)
+	f, err := os.OpenFile(filepath.Join(outputPath, "small_provinces_list.txt"), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0775)
 	if err != nil {
 		return err
 	}
@@ -1803,7 +2024,12 @@ func generateSmallProvincesMap(threshold int) error {
 	}
 	for _, pID := range smallProvinceList {
 		prov := provincesIDMap[pID]
-		s := strconv.Itoa(prov.ID) + "\t" + strconv.Itoa(prov.State.ID) + "\t(" + strconv.Itoa(prov.CenterPoint.X) + "," + strconv.Itoa(prov.CenterPoint.Y) + ")\t" + strconv.Itoa(len(prov.PixelCoords)) + "\n"
+		// Land provinces outside of any state are listed with a "-" state.
+		stateID := "-"
+		if prov.State != nil {
+			stateID = strconv.Itoa(prov.State.ID)
+		}
+		s := strconv.Itoa(prov.ID) + "\t" + stateID + "\t(" + strconv.Itoa(prov.CenterPoint.X) + "," + strconv.Itoa(prov.CenterPoint.Y) + ")\t" + strconv.Itoa(len(prov.PixelCoords)) + "\n"
 		if _, err = f.WriteString(s); err != nil {
 			return err
 		}
@@ -1811,7 +2037,7 @@ func generateSmallProvincesMap(threshold int) error {
 	fmt.Printf("%s: Saved 'small_provinces_list.txt'\n", time.Since(startTime))
 
 	// Save image as PNG.
-	out, err := os.Create("./small_provinces_map.png")
+	out, err := os.Create(filepath.Join(outputPath, "small_provinces_map.png"))
 	if err != nil {
 		return err
 	}
@@ -1902,7 +2128,7 @@ func generateSmallProvincesMap(threshold int) error {
 	}
 
 	// Save image as PNG.
-	out, err = os.Create("./small_provinces_map_x4.png")
+	out, err = os.Create(filepath.Join(outputPath, "small_provinces_map_x4.png"))
 	if err != nil {
 		return err
 	}
@@ -1962,7 +2188,7 @@ func generateColorShuffledProvinceMap() error {
 	}
 
 	// Save image as PNG.
-	out, err := os.Create("./color_shuffled_province_map.png")
+	out, err := os.Create(filepath.Join(outputPath, "color_shuffled_province_map.png"))
 	if err != nil {
 		return err
 	}
@@ -1973,28 +2199,13 @@ func generateColorShuffledProvinceMap() error {
 	fmt.Printf("%s: Saved 'color_shuffled_province_map.png'\n", time.Since(startTime))
 
 	// Write new definition.csv.
-	var IDs []int
-	for id := range provincesIDMap {
-		IDs = append(IDs, id)
-	}
-	sort.Ints(IDs)
-
-	f, err := os.OpenFile("definition.csv", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0775)
-	if err != nil {
-		return err
+	var shuffled []Province
+	for _, id := range sortedKeySliceFromProvinceMap(provincesIDMap) {
+		p := *provincesIDMap[id]
+		p.RGB = p.RenderColor
+		shuffled = append(shuffled, p)
 	}
-	defer f.Close()
-
-	for _, id := range IDs {
-		p := provincesIDMap[id]
-		s := fmt.Sprintf("%v;%v;%v;%v;%v;%v;%v;%v\r\n", p.ID, p.RenderColor.R, p.RenderColor.G, p.RenderColor.B, p.Type, p.IsCoastal, p.Terrain, p.Continent)
-		if _, err = f.WriteString(s); err != nil {
-			return err
-		}
-	}
-	fmt.Printf("%s: Saved 'definition.csv'\n", time.Since(startTime))
-
-	return nil
+	return saveDefinitions("definition.csv", shuffled, false)
 }
 
 func generateImpassableMap() error {
@@ -2014,7 +2225,7 @@ func generateImpassableMap() error {
 	}
 
 	// Save image as PNG.
-	out, err := os.Create("./impassable_map.png")
+	out, err := os.Create(filepath.Join(outputPath, "impassable_map.png"))
 	if err != nil {
 		return err
 	}
@@ -2053,89 +2264,3 @@ func generateRandomSeaColor() color.RGBA {
 	minB := 16
 	return color.RGBA{uint8(rand.Intn(maxR-minR) + minR), uint8(rand.Intn(maxG-minG) + minG), uint8(rand.Intn(maxB-minB) + minB), 255}
 }
-
-func generateProvinceContinentValues(continentsPath string) error {
-	fmt.Printf("%s: Generating new province continent values...\n", time.Since(startTime))
-
-	c1 := color.RGBA{75, 43, 7, 255}     // brown: west_coast
-	c2 := color.RGBA{255, 255, 255, 255} // white: northern_reaches
-	c3 := color.RGBA{11, 103, 0, 255}    // green: land_of_titans
-	c4 := color.RGBA{222, 221, 39, 255}  // yellow: midwest
-	c5 := color.RGBA{169, 45, 45, 255}   // red: east_coast
-	c6 := color.RGBA{0, 210, 235, 255}   // cyan: caribbean_expanse
-
-	continentsFile, err := os.Open(filepath.FromSlash(continentsPath))
-	if err != nil {
-		return err
-	}
-	defer continentsFile.Close()
-	continentsImage, _, err := image.Decode(continentsFile)
-	if err != nil {
-		return err
-	}
-
-	// Write new definition.csv.
-	var IDs []int
-	for id := range provincesIDMap {
-		IDs = append(IDs, id)
-	}
-	sort.Ints(IDs)
-
-	f, err := os.OpenFile("definition.csv", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0775)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	for _, id := range IDs {
-		p := provincesIDMap[id]
-		continent := 0
-
-		if p.Terrain != "ocean" {
-			var col1 color.RGBA
-			for _, px := range p.PixelCoords {
-				col2 := continentsImage.At(px.X, px.Y)
-				r1, g1, b1, a1 := col1.RGBA()
-				r2, g2, b2, a2 := col2.RGBA()
-
-				if a1 != 0 && r1 != r2 && g1 != g2 && b1 != b2 && a1 != a2 {
-					return fmt.Errorf("Different continent colors in province %v at %v", p.ID, px)
-				}
-
-				col1 = col2.(color.RGBA)
-
-				switch fmt.Sprintf("%v", col2) {
-				case fmt.Sprintf("%v", c1):
-					continent = 1
-				case fmt.Sprintf("%v", c2):
-					continent = 2
-				case fmt.Sprintf("%v", c3):
-					continent = 3
-				case fmt.Sprintf("%v", c4):
-					continent = 4
-				case fmt.Sprintf("%v", c5):
-					continent = 5
-				case fmt.Sprintf("%v", c6):
-					continent = 6
-				}
-			}
-		}
-
-		s := fmt.Sprintf("%v;%v;%v;%v;%v;%v;%v;%v\r\n", p.ID, p.RGB.R, p.RGB.G, p.RGB.B, p.Type, p.IsCoastal, p.Terrain, continent)
-		if _, err = f.WriteString(s); err != nil {
-			return err
-		}
-	}
-	fmt.Printf("%s: Saved 'definition.csv'\n", time.Since(startTime))
-
-	return nil
-}
-
-func containsPoint(s []image.Point, a image.Point) bool {
-	for _, b := range s {
-		if b.X == a.X && b.Y == a.Y {
-			return true
-		}
-	}
-	return false
-}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"flag"
+	"image"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// SetupFlagsTest gives parseFlags a fresh flag set
+// and restores the flag variables after the test.
+func setupFlagsTest(t *testing.T) {
+	oldCommandLine := flag.CommandLine
+	oldGame, oldMods, oldFS, oldOutput := gamePath, modPaths, gameFS, outputPath
+	oldDefinitions, oldAdjacencies, oldRules := definitionsPath, adjacenciesPath, adjacencyRulesPath
+	oldProvinces, oldTerrain, oldHeightmap := provincesPath, terrainPath, heightmapPath
+	oldTerrainCategories, oldStates, oldRegions, oldCategories := terrainCategoriesPath, statesPath, strategicRegionPath, stateCategoryPath
+	oldTags, oldCountries, oldLocalisation := countryTagsPath, countriesPath, localisationPath
+	oldLanguage, oldFont, oldCenter, oldDate, oldLenient := language, fontPath, centerMethod, startDate, lenientParsing
+	t.Cleanup(func() {
+		flag.CommandLine = oldCommandLine
+		gamePath, modPaths, gameFS, outputPath = oldGame, oldMods, oldFS, oldOutput
+		definitionsPath, adjacenciesPath, adjacencyRulesPath = oldDefinitions, oldAdjacencies, oldRules
+		provincesPath, terrainPath, heightmapPath = oldProvinces, oldTerrain, oldHeightmap
+		terrainCategoriesPath, statesPath, strategicRegionPath, stateCategoryPath = oldTerrainCategories, oldStates, oldRegions, oldCategories
+		countryTagsPath, countriesPath, localisationPath = oldTags, oldCountries, oldLocalisation
+		language, fontPath, centerMethod, startDate, lenientParsing = oldLanguage, oldFont, oldCenter, oldDate, oldLenient
+	})
+
+	flag.CommandLine = flag.NewFlagSet("hoi4geoparser", flag.ContinueOnError)
+	flag.CommandLine.SetOutput(ioutil.Discard)
+	modPaths = nil
+}
+
+func TestParseFlags(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, filepath.Join(root, "game"), "map/definition.csv", "map/adjacencies.csv", "map/provinces.bmp")
+	writeTestFiles(t, filepath.Join(root, "mod"), "map/definition.csv", "map/terrain.bmp")
+	game := filepath.Join(root, "game")
+	mod := filepath.Join(root, "mod")
+	explicit := filepath.ToSlash(filepath.Join(root, "other", "definition.csv"))
+
+	tests := []struct {
+		name  string
+		args  []string
+		paths map[*string]string // Resolved path relative to root.
+		err   string
+	}{
+		{
+			name: "game",
+			args: []string{"-game", game, "render", "states"},
+			paths: map[*string]string{
+				&definitionsPath: "game/map/definition.csv",
+				&provincesPath:   "game/map/provinces.bmp",
+			},
+		},
+		{
+			name: "mod over game",
+			args: []string{"-game", game, "-mod", mod, "render", "states"},
+			paths: map[*string]string{
+				&definitionsPath: "mod/map/definition.csv",
+				&adjacenciesPath: "game/map/adjacencies.csv",
+				&terrainPath:     "mod/map/terrain.bmp",
+			},
+		},
+		{
+			name: "mod only",
+			args: []string{"-mod", mod, "render", "states"},
+			paths: map[*string]string{
+				&definitionsPath: "mod/map/definition.csv",
+				// Missing files resolve into the last layer and are reported when opened.
+				&adjacenciesPath: "mod/map/adjacencies.csv",
+			},
+		},
+		{
+			name: "explicit path over mod and game",
+			args: []string{"-game", game, "-mod", mod, "-definitions", explicit, "render", "states"},
+			paths: map[*string]string{
+				&definitionsPath: "other/definition.csv",
+				&adjacenciesPath: "game/map/adjacencies.csv",
+			},
+		},
+		{
+			name: "no game or mod",
+			args: []string{"-definitions", explicit, "render", "states"},
+			err:  "either -game or -mod must be set",
+		},
+		{
+			name: "unknown center method",
+			args: []string{"-game", game, "-center", "median", "render", "states"},
+			err:  "unknown center point method: median",
+		},
+		{
+			name: "unknown flag",
+			args: []string{"-gmae", game, "render", "states"},
+			err:  "flag provided but not defined: -gmae",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupFlagsTest(t)
+			args := append([]string{"-out", t.TempDir()}, tt.args...)
+			_, err := parseFlags(args)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for p, want := range tt.paths {
+				if got := relPaths(t, root, []string{filepath.FromSlash(*p)}); got != want {
+					t.Errorf("got %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseStrategicRegionsProvinces(t *testing.T) {
+	// Land provinces 1, 2 and 5 around sea provinces 3 and 4,
+	// land province 7 below provinces 5 and 6, province 6 is in no strategic region.
+	setupValidationTest(t, map[int]string{3: "sea", 4: "sea"}, "123456", "....77")
+	p := provincesIDMap
+	// A strait connects provinces 1 and 5 that do not share a border.
+	p[1].ConnectedTo[5] = p[5]
+	p[5].ConnectedTo[1] = p[1]
+	p[2].ConnectedTo[1] = p[1]
+
+	region := func(id int, provinces ...int) *StrategicRegion {
+		r := &StrategicRegion{
+			ID:             id,
+			Provinces:      make(map[int]*Province),
+			PixelCoordsMap: make(map[image.Point]bool),
+			AdjacentTo:     make(map[int]*StrategicRegion),
+			ConnectedTo:    make(map[int]*StrategicRegion),
+		}
+		for _, id := range provinces {
+			r.Provinces[id] = p[id]
+		}
+		strategicRegionMap[id] = r
+		return r
+	}
+	region(1, 1, 2)
+	region(2, 3, 4)
+	region(3, 5)
+	region(4)
+	region(5, 7)
+
+	parseStrategicRegionsProvinces()
+
+	tests := []struct {
+		id          int
+		isSea       bool
+		pixels      int
+		adjacentTo  []int
+		connectedTo []int
+	}{
+		// Coastal land regions are connected to the sea region, region 1 also to region 3 by the strait.
+		{1, false, 2, []int{2}, []int{2, 3}},
+		{2, true, 2, []int{1, 3}, []int{1, 3}},
+		{3, false, 1, []int{2, 5}, []int{1, 2}},
+		{4, false, 0, nil, nil},
+		// Bordering land regions are only connected by adjacencies.csv.
+		{5, false, 2, []int{3}, nil},
+	}
+	for _, tt := range tests {
+		r := strategicRegionMap[tt.id]
+		if r.IsSea != tt.isSea {
+			t.Errorf("region %v: got IsSea %v, want %v", tt.id, r.IsSea, tt.isSea)
+		}
+		if len(r.PixelCoords) != tt.pixels || len(r.PixelCoordsMap) != tt.pixels {
+			t.Errorf("region %v: got %v pixels, want %v", tt.id, len(r.PixelCoords), tt.pixels)
+		}
+		if got := sortedKeySliceFromStrategicRegionMap(r.AdjacentTo); joinInts(got) != joinInts(tt.adjacentTo) {
+			t.Errorf("region %v: got adjacent to %v, want %v", tt.id, got, tt.adjacentTo)
+		}
+		if got := sortedKeySliceFromStrategicRegionMap(r.ConnectedTo); joinInts(got) != joinInts(tt.connectedTo) {
+			t.Errorf("region %v: got connected to %v, want %v", tt.id, got, tt.connectedTo)
+		}
+		for _, prov := range r.Provinces {
+			if prov.StrategicRegion != r {
+				t.Errorf("region %v: province %v has region %v", tt.id, prov.ID, prov.StrategicRegion)
+			}
+		}
+	}
+	if p[6].StrategicRegion != nil {
+		t.Errorf("province 6: got region %v, want none", p[6].StrategicRegion.ID)
+	}
+	if c := strategicRegionMap[3].CenterPoint; c != (image.Point{4, 0}) {
+		t.Errorf("region 3: got center %v, want (4,0)", c)
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// FilePos represents a position in a parsed file.
+// Column is zero for line based formats.
+type filePos struct {
+	Path   string
+	Line   int
+	Column int
+}
+
+func (p filePos) String() string {
+	if p.Column == 0 {
+		return fmt.Sprintf("%v:%v", p.Path, p.Line)
+	}
+	return fmt.Sprintf("%v:%v:%v", p.Path, p.Line, p.Column)
+}
+
+// FileError is an error at a specific position in a file.
+type fileError struct {
+	Pos filePos
+	Msg string
+}
+
+func (e *fileError) Error() string {
+	return e.Pos.String() + ": " + e.Msg
+}
+
+func newFileError(pos filePos, format string, a ...interface{}) *fileError {
+	return &fileError{Pos: pos, Msg: fmt.Sprintf(format, a...)}
+}
+
+// ScriptNode represents a single entry of a Paradox script file.
+//
+// An entry is either an assignment "key = value", "key = { ... }"
+// or "key = rgb { ... }", or a bare value inside a list like "{ 1 2 3 }",
+// in which case Key and Operator are empty.
+type scriptNode struct {
+	Key      string
+	Operator string // "=", "<", ">", "<=", ">=", "!=", "==" or "?=".
+	Value    string // Scalar value or block tag like "rgb", empty for plain blocks.
+	Quoted   bool   // Value was a quoted string.
+	IsBlock  bool
+	Children []*scriptNode
+	Pos      filePos
+}
+
+// Find returns the first child with the given key or nil.
+// Keys are compared case-insensitively as the game does.
+func (n *scriptNode) Find(key string) *scriptNode {
+	for _, c := range n.Children {
+		if strings.EqualFold(c.Key, key) {
+			return c
+		}
+	}
+	return nil
+}
+
+// FindAll returns every child with the given key.
+func (n *scriptNode) FindAll(key string) (nodes []*scriptNode) {
+	for _, c := range n.Children {
+		if strings.EqualFold(c.Key, key) {
+			nodes = append(nodes, c)
+		}
+	}
+	return nodes
+}
+
+// Values returns the bare values of a list block.
+func (n *scriptNode) Values() (nodes []*scriptNode) {
+	for _, c := range n.Children {
+		if c.Key == "" && !c.IsBlock {
+			nodes = append(nodes, c)
+		}
+	}
+	return nodes
+}
+
+// Int returns the value as an integer.
+func (n *scriptNode) Int() (int, error) {
+	if n.IsBlock {
+		return 0, newFileError(n.Pos, "%v: expected integer, found block", n.Key)
+	}
+	i, err := strconv.Atoi(n.Value)
+	if err != nil {
+		return 0, newFileError(n.Pos, "%v: expected integer, found %q", n.Key, n.Value)
+	}
+	return i, nil
+}
+
+// Float returns the value as a floating point number.
+func (n *scriptNode) Float() (float64, error) {
+	if n.IsBlock {
+		return 0, newFileError(n.Pos, "%v: expected number, found block", n.Key)
+	}
+	f, err := strconv.ParseFloat(n.Value, 64)
+	if err != nil {
+		return 0, newFileError(n.Pos, "%v: expected number, found %q", n.Key, n.Value)
+	}
+	return f, nil
+}
+
+// Bool returns true for "yes" and false for "no".
+func (n *scriptNode) Bool() (bool, error) {
+	switch {
+	case !n.IsBlock && strings.EqualFold(n.Value, "yes"):
+		return true, nil
+	case !n.IsBlock && strings.EqualFold(n.Value, "no"):
+		return false, nil
+	}
+	return false, newFileError(n.Pos, "%v: expected yes or no, found %q", n.Key, n.Value)
+}
+
+// Ints returns the bare values of a list block as integers.
+func (n *scriptNode) Ints() (ints []int, err error) {
+	if !n.IsBlock {
+		return nil, newFileError(n.Pos, "%v: expected block, found %q", n.Key, n.Value)
+	}
+	for _, v := range n.Values() {
+		i, err := v.Int()
+		if err != nil {
+			return nil, err
+		}
+		ints = append(ints, i)
+	}
+	return ints, nil
+}
+
+// ParseScriptFile parses a Paradox script file
+// and returns a root block with all entries of the file.
+func parseScriptFile(path string) (*scriptNode, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseScript(path, b)
+}
+
+func parseScript(path string, b []byte) (*scriptNode, error) {
+	p := &scriptParser{lexer: scriptLexer{path: path, src: bytes.TrimPrefix(b, utf8bom), line: 1, column: 1}}
+	root := &scriptNode{IsBlock: true, Pos: filePos{Path: path, Line: 1, Column: 1}}
+	err := p.parseBlock(root, false)
+	if err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+type scriptTokenKind int
+
+const (
+	tokenEOF scriptTokenKind = iota
+	tokenWord
+	tokenString
+	tokenOperator
+	tokenOpen
+	tokenClose
+)
+
+type scriptToken struct {
+	Kind scriptTokenKind
+	Text string
+	Pos  filePos
+}
+
+type scriptLexer struct {
+	path   string
+	src    []byte
+	offset int
+	line   int
+	column int
+}
+
+func (l *scriptLexer) pos() filePos {
+	return filePos{Path: l.path, Line: l.line, Column: l.column}
+}
+
+func (l *scriptLexer) advance() byte {
+	c := l.src[l.offset]
+	l.offset++
+	if c == '\n' {
+		l.line++
+		l.column = 1
+	} else {
+		l.column++
+	}
+	return c
+}
+
+func (l *scriptLexer) peekByte(i int) byte {
+	if l.offset+i < len(l.src) {
+		return l.src[l.offset+i]
+	}
+	return 0
+}
+
+func isScriptDelimiter(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', '{', '}', '=', '<', '>', '!', '?', '#', '"':
+		return true
+	}
+	return false
+}
+
+func (l *scriptLexer) next() (scriptToken, error) {
+	// Skip whitespace and comments.
+	for l.offset < len(l.src) {
+		c := l.src[l.offset]
+		if c == '#' {
+			for l.offset < len(l.src) && l.src[l.offset] != '\n' {
+				l.advance()
+			}
+			continue
+		}
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			break
+		}
+		l.advance()
+	}
+
+	pos := l.pos()
+	if l.offset >= len(l.src) {
+		return scriptToken{Kind: tokenEOF, Pos: pos}, nil
+	}
+
+	c := l.advance()
+	switch c {
+	case '{':
+		return scriptToken{Kind: tokenOpen, Text: "{", Pos: pos}, nil
+	case '}':
+		return scriptToken{Kind: tokenClose, Text: "}", Pos: pos}, nil
+	case '=', '<', '>', '!', '?':
+		op := string(c)
+		if l.peekByte(0) == '=' {
+			op += string(l.advance())
+		}
+		if op == "!" || op == "?" {
+			return scriptToken{}, newFileError(pos, "unexpected %q", op)
+		}
+		return scriptToken{Kind: tokenOperator, Text: op, Pos: pos}, nil
+	case '"':
+		var sb strings.Builder
+		for {
+			if l.offset >= len(l.src) {
+				return scriptToken{}, newFileError(pos, "unterminated string")
+			}
+			c = l.advance()
+			if c == '"' {
+				break
+			}
+			if c == '\\' && (l.peekByte(0) == '"' || l.peekByte(0) == '\\') {
+				c = l.advance()
+			}
+			sb.WriteByte(c)
+		}
+		return scriptToken{Kind: tokenString, Text: sb.String(), Pos: pos}, nil
+	}
+
+	start := l.offset - 1
+	for l.offset < len(l.src) && !isScriptDelimiter(l.src[l.offset]) {
+		l.advance()
+	}
+	return scriptToken{Kind: tokenWord, Text: string(l.src[start:l.offset]), Pos: pos}, nil
+}
+
+type scriptParser struct {
+	lexer  scriptLexer
+	peeked *scriptToken
+}
+
+func (p *scriptParser) next() (scriptToken, error) {
+	if p.peeked != nil {
+		t := *p.peeked
+		p.peeked = nil
+		return t, nil
+	}
+	return p.lexer.next()
+}
+
+func (p *scriptParser) peek() (scriptToken, error) {
+	if p.peeked == nil {
+		t, err := p.lexer.next()
+		if err != nil {
+			return t, err
+		}
+		p.peeked = &t
+	}
+	return *p.peeked, nil
+}
+
+// ParseBlock reads entries into n until the closing bracket
+// or until the end of file for the root block.
+func (p *scriptParser) parseBlock(n *scriptNode, closed bool) error {
+	for {
+		t, err := p.next()
+		if err != nil {
+			return err
+		}
+
+		switch t.Kind {
+		case tokenEOF:
+			if closed {
+				return newFileError(n.Pos, "missing closing bracket")
+			}
+			return nil
+		case tokenClose:
+			if !closed {
+				return newFileError(t.Pos, "unexpected closing bracket")
+			}
+			return nil
+		case tokenOperator:
+			return newFileError(t.Pos, "unexpected %q", t.Text)
+		case tokenOpen:
+			// Anonymous block inside a list.
+			c := &scriptNode{IsBlock: true, Pos: t.Pos}
+			err = p.parseBlock(c, true)
+			if err != nil {
+				return err
+			}
+			n.Children = append(n.Children, c)
+			continue
+		}
+
+		op, err := p.peek()
+		if err != nil {
+			return err
+		}
+		if op.Kind != tokenOperator {
+			// Bare value inside a list.
+			n.Children = append(n.Children, &scriptNode{Value: t.Text, Quoted: t.Kind == tokenString, Pos: t.Pos})
+			continue
+		}
+		p.next()
+
+		c := &scriptNode{Key: t.Text, Operator: op.Text, Pos: t.Pos}
+		err = p.parseValue(c)
+		if err != nil {
+			return err
+		}
+		n.Children = append(n.Children, c)
+	}
+}
+
+// ParseValue reads the right-hand side of an assignment.
+func (p *scriptParser) parseValue(n *scriptNode) error {
+	t, err := p.next()
+	if err != nil {
+		return err
+	}
+
+	switch t.Kind {
+	case tokenOpen:
+		n.IsBlock = true
+		return p.parseBlock(n, true)
+	case tokenWord, tokenString:
+		n.Value = t.Text
+		n.Quoted = t.Kind == tokenString
+	default:
+		return newFileError(t.Pos, "%v: missing value after %q", n.Key, n.Operator)
+	}
+
+	// Tagged blocks like "color = rgb { 255 0 0 }".
+	if t.Kind == tokenWord && isScriptBlockTag(t.Text) {
+		open, err := p.peek()
+		if err != nil {
+			return err
+		}
+		if open.Kind == tokenOpen {
+			p.next()
+			n.IsBlock = true
+			return p.parseBlock(n, true)
+		}
+	}
+	return nil
+}
+
+func isScriptBlockTag(s string) bool {
+	switch strings.ToLower(s) {
+	case "rgb", "hsv", "hsv360", "hex":
+		return true
+	}
+	return false
+}
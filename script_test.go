@@ -0,0 +1,211 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScriptLexer(t *testing.T) {
+	tests := []struct {
+		src    string
+		tokens []scriptToken
+	}{
+		{
+			src: "a = 1",
+			tokens: []scriptToken{
+				{Kind: tokenWord, Text: "a", Pos: filePos{"f", 1, 1}},
+				{Kind: tokenOperator, Text: "=", Pos: filePos{"f", 1, 3}},
+				{Kind: tokenWord, Text: "1", Pos: filePos{"f", 1, 5}},
+				{Kind: tokenEOF, Pos: filePos{"f", 1, 6}},
+			},
+		},
+		{
+			src: "# comment\n\ta<=b#trailing\n}",
+			tokens: []scriptToken{
+				{Kind: tokenWord, Text: "a", Pos: filePos{"f", 2, 2}},
+				{Kind: tokenOperator, Text: "<=", Pos: filePos{"f", 2, 3}},
+				{Kind: tokenWord, Text: "b", Pos: filePos{"f", 2, 5}},
+				{Kind: tokenClose, Text: "}", Pos: filePos{"f", 3, 1}},
+				{Kind: tokenEOF, Pos: filePos{"f", 3, 2}},
+			},
+		},
+		{
+			src: `x != "a \"b\" # c" ?= {`,
+			tokens: []scriptToken{
+				{Kind: tokenWord, Text: "x", Pos: filePos{"f", 1, 1}},
+				{Kind: tokenOperator, Text: "!=", Pos: filePos{"f", 1, 3}},
+				{Kind: tokenString, Text: `a "b" # c`, Pos: filePos{"f", 1, 6}},
+				{Kind: tokenOperator, Text: "?=", Pos: filePos{"f", 1, 20}},
+				{Kind: tokenOpen, Text: "{", Pos: filePos{"f", 1, 23}},
+				{Kind: tokenEOF, Pos: filePos{"f", 1, 24}},
+			},
+		},
+		{
+			src: "a>b==c",
+			tokens: []scriptToken{
+				{Kind: tokenWord, Text: "a", Pos: filePos{"f", 1, 1}},
+				{Kind: tokenOperator, Text: ">", Pos: filePos{"f", 1, 2}},
+				{Kind: tokenWord, Text: "b", Pos: filePos{"f", 1, 3}},
+				{Kind: tokenOperator, Text: "==", Pos: filePos{"f", 1, 4}},
+				{Kind: tokenWord, Text: "c", Pos: filePos{"f", 1, 6}},
+				{Kind: tokenEOF, Pos: filePos{"f", 1, 7}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		l := scriptLexer{path: "f", src: []byte(tt.src), line: 1, column: 1}
+		for i, want := range tt.tokens {
+			got, err := l.next()
+			if err != nil {
+				t.Errorf("%q: token %v: unexpected error: %v", tt.src, i, err)
+				break
+			}
+			if got != want {
+				t.Errorf("%q: token %v: got %+v, want %+v", tt.src, i, got, want)
+			}
+		}
+	}
+}
+
+// FormatScriptNode writes the children of n in a compact form
+// like `a=1 b={2 3} c=rgb{1 2 3} "d"` to compare parse results.
+func formatScriptNode(n *scriptNode) string {
+	var s []string
+	for _, c := range n.Children {
+		var sb strings.Builder
+		if c.Key != "" {
+			sb.WriteString(c.Key + c.Operator)
+		}
+		value := c.Value
+		if c.Quoted {
+			value = `"` + value + `"`
+		}
+		sb.WriteString(value)
+		if c.IsBlock {
+			sb.WriteString("{" + formatScriptNode(c) + "}")
+		}
+		s = append(s, sb.String())
+	}
+	return strings.Join(s, " ")
+}
+
+func TestParseScript(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"", ""},
+		{"\xEF\xBB\xBFa = 1", "a=1"},
+		{"a = 1 # comment\nb = yes", "a=1 b=yes"},
+		{"state = { id = 1 provinces = { 1 2 3 } }", "state={id=1 provinces={1 2 3}}"},
+		{`name = "Old \"South\""`, `name="Old "South""`},
+		{"limit = { a < 1 b >= 2 c != x d ?= y }", "limit={a<1 b>=2 c!=x d?=y}"},
+		{"color = rgb { 255 0 0 } c2 = HSV { 0.5 1 1 }", "color=rgb{255 0 0} c2=HSV{0.5 1 1}"},
+		{"color = { 1 2 3 }", "color={1 2 3}"},
+		{"flag = rgb", "flag=rgb"},
+		{"list = { { a = 1 } { a = 2 } }", "list={{a=1} {a=2}}"},
+		{"a = {}", "a={}"},
+	}
+
+	for _, tt := range tests {
+		root, err := parseScript("f", []byte(tt.src))
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.src, err)
+			continue
+		}
+		if got := formatScriptNode(root); got != tt.want {
+			t.Errorf("%q: got %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestParseScriptPositions(t *testing.T) {
+	root, err := parseScript("f", []byte("a = 1\nb = {\n\tc = rgb { 1 2 3 }\n}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		node *scriptNode
+		want filePos
+	}{
+		{root, filePos{"f", 1, 1}},
+		{root.Find("a"), filePos{"f", 1, 1}},
+		{root.Find("b"), filePos{"f", 2, 1}},
+		{root.Find("b").Find("c"), filePos{"f", 3, 2}},
+		{root.Find("b").Find("c").Values()[2], filePos{"f", 3, 16}},
+	}
+	for i, tt := range tests {
+		if tt.node.Pos != tt.want {
+			t.Errorf("node %v: got position %v, want %v", i, tt.node.Pos, tt.want)
+		}
+	}
+}
+
+func TestParseScriptErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"a = { b = 1", "f:1:1: missing closing bracket"},
+		{"a = 1\n}", "f:2:1: unexpected closing bracket"},
+		{"a = 1\n  = 2", "f:2:3: unexpected \"=\""},
+		{"a =", "f:1:4: a: missing value after \"=\""},
+		{"a = }", "f:1:5: a: missing value after \"=\""},
+		{"a ! b", "f:1:3: unexpected \"!\""},
+		{"a ? b", "f:1:3: unexpected \"?\""},
+		{"a = \"b\nc", "f:1:5: unterminated string"},
+	}
+
+	for _, tt := range tests {
+		_, err := parseScript("f", []byte(tt.src))
+		if err == nil {
+			t.Errorf("%q: expected error %q", tt.src, tt.want)
+			continue
+		}
+		if _, ok := err.(*fileError); !ok {
+			t.Errorf("%q: got %T, want *fileError", tt.src, err)
+		}
+		if err.Error() != tt.want {
+			t.Errorf("%q: got error %q, want %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestScriptNodeValues(t *testing.T) {
+	root, err := parseScript("f", []byte("i = 12 f = 0.5 y = YES n = no ints = { 1 2 3 } s = x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i, err := root.Find("I").Int(); err != nil || i != 12 {
+		t.Errorf("Int: got %v, %v", i, err)
+	}
+	if f, err := root.Find("f").Float(); err != nil || f != 0.5 {
+		t.Errorf("Float: got %v, %v", f, err)
+	}
+	if b, err := root.Find("y").Bool(); err != nil || !b {
+		t.Errorf("Bool yes: got %v, %v", b, err)
+	}
+	if b, err := root.Find("n").Bool(); err != nil || b {
+		t.Errorf("Bool no: got %v, %v", b, err)
+	}
+	if ints, err := root.Find("ints").Ints(); err != nil || len(ints) != 3 || ints[2] != 3 {
+		t.Errorf("Ints: got %v, %v", ints, err)
+	}
+
+	errorTests := []struct {
+		err  error
+		want string
+	}{
+		{func() error { _, err := root.Find("s").Int(); return err }(), `f:1:48: s: expected integer, found "x"`},
+		{func() error { _, err := root.Find("ints").Int(); return err }(), "f:1:31: ints: expected integer, found block"},
+		{func() error { _, err := root.Find("s").Bool(); return err }(), `f:1:48: s: expected yes or no, found "x"`},
+		{func() error { _, err := root.Find("s").Ints(); return err }(), `f:1:48: s: expected block, found "x"`},
+	}
+	for _, tt := range errorTests {
+		if tt.err == nil || tt.err.Error() != tt.want {
+			t.Errorf("got error %v, want %q", tt.err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// StateCategory represents an in-game state category like "town" or "megalopolis".
+type StateCategory struct {
+	Name          string
+	BuildingSlots int
+}
+
+func parseStateCategoryFiles() error {
+	fmt.Printf("%s: Parsing state category files...\n", time.Since(startTime))
+	stateCategoryFiles, err := globInputFiles(stateCategoryPath, "common/state_category", "*.txt")
+	if err != nil {
+		return err
+	}
+	if len(stateCategoryFiles) == 0 {
+		fmt.Printf("%s: No state category files found, building slots are set to zero\n", time.Since(startTime))
+	}
+	for _, path := range stateCategoryFiles {
+		root, err := parseScriptFile(path)
+		if err != nil {
+			return err
+		}
+		for _, categories := range root.FindAll("state_categories") {
+			if !categories.IsBlock {
+				return newFileError(categories.Pos, "state_categories: expected block")
+			}
+			for _, n := range categories.Children {
+				category, err := parseStateCategory(n)
+				if err != nil {
+					return err
+				}
+				stateCategoriesMap[category.Name] = &category
+			}
+		}
+	}
+	return nil
+}
+
+func parseStateCategory(n *scriptNode) (category StateCategory, err error) {
+	if !n.IsBlock {
+		return category, newFileError(n.Pos, "%v: expected block", n.Key)
+	}
+	category.Name = n.Key
+	if v := n.Find("local_building_slots"); v != nil {
+		category.BuildingSlots, err = v.Int()
+		if err != nil {
+			return category, err
+		}
+	}
+	return category, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseStateCategoryFiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  map[string]int // Category name to its building slots.
+		err   string
+	}{
+		{
+			name: "categories",
+			files: map[string]string{
+				"00_town.txt":  "state_categories = { town = { local_building_slots = 4 color = { 1 2 3 } } }",
+				"01_other.txt": "state_categories = { wasteland = { } megalopolis = { local_building_slots = 12 } }",
+			},
+			want: map[string]int{"town": 4, "wasteland": 0, "megalopolis": 12},
+		},
+		{
+			name:  "no files",
+			files: map[string]string{},
+			want:  map[string]int{},
+		},
+		{
+			name:  "not a block",
+			files: map[string]string{"a.txt": "state_categories = town"},
+			err:   "/a.txt:1:1: state_categories: expected block",
+		},
+		{
+			name:  "category not a block",
+			files: map[string]string{"a.txt": "state_categories = { town = 4 }"},
+			err:   "/a.txt:1:22: town: expected block",
+		},
+		{
+			name:  "invalid building slots",
+			files: map[string]string{"a.txt": "state_categories = { town = { local_building_slots = x } }"},
+			err:   `/a.txt:1:31: local_building_slots: expected integer, found "x"`,
+		},
+	}
+
+	oldPath, oldMap := stateCategoryPath, stateCategoriesMap
+	defer func() { stateCategoryPath, stateCategoriesMap = oldPath, oldMap }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, src := range tt.files {
+				err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0664)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			stateCategoryPath = dir
+			stateCategoriesMap = make(map[string]*StateCategory)
+
+			err := parseStateCategoryFiles()
+			if tt.err != "" {
+				if err == nil || err.Error() != filepath.ToSlash(dir)+tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(stateCategoriesMap) != len(tt.want) {
+				t.Errorf("got %v categories, want %v", len(stateCategoriesMap), len(tt.want))
+			}
+			for name, slots := range tt.want {
+				c, ok := stateCategoriesMap[name]
+				if !ok {
+					t.Errorf("%v: missing category", name)
+					continue
+				}
+				if c.Name != name || c.BuildingSlots != slots {
+					t.Errorf("%v: got %+v, want %v building slots", name, c, slots)
+				}
+			}
+		})
+	}
+}
+
+func TestParseStateCategoryAndResources(t *testing.T) {
+	oldMap, oldIssues, oldLenient := stateCategoriesMap, validationIssues, lenientParsing
+	defer func() { stateCategoriesMap, validationIssues, lenientParsing = oldMap, oldIssues, oldLenient }()
+	stateCategoriesMap = map[string]*StateCategory{"town": {Name: "town", BuildingSlots: 4}}
+	lenientParsing = true
+
+	tests := []struct {
+		src       string
+		category  string
+		slots     int
+		resources map[string]float64
+		issues    []string
+	}{
+		{
+			src:       "state = { id = 1 state_category = town resources = { Oil = 5 steel = 2.5 } history = { add_extra_state_shared_building_slots = 2 } }",
+			category:  "town",
+			slots:     6,
+			resources: map[string]float64{"oil": 5, "steel": 2.5},
+		},
+		{
+			src:       "state = { id = 2 resources = { oil = 1 } resources = { rubber = 3 } }",
+			resources: map[string]float64{"oil": 1, "rubber": 3},
+		},
+		{
+			src:       "state = { id = 3 state_category = city history = { add_extra_state_shared_building_slots = 1 } }",
+			category:  "city",
+			slots:     1,
+			resources: map[string]float64{},
+			issues:    []string{`f:1:18: unknown state_category "city" of state 3`},
+		},
+	}
+
+	for _, tt := range tests {
+		validationIssues = nil
+		root, err := parseScript("f", []byte(tt.src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		state, err := parseState(root.Find("state"))
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.src, err)
+			continue
+		}
+		if state.Category != tt.category || state.BuildingSlots != tt.slots {
+			t.Errorf("%q: got category %q with %v building slots, want %q with %v", tt.src, state.Category, state.BuildingSlots, tt.category, tt.slots)
+		}
+		if len(state.Resources) != len(tt.resources) {
+			t.Errorf("%q: got resources %v, want %v", tt.src, state.Resources, tt.resources)
+		}
+		for r, v := range tt.resources {
+			if state.Resources[r] != v {
+				t.Errorf("%q: got resources %v, want %v", tt.src, state.Resources, tt.resources)
+				break
+			}
+		}
+		got := issueMessages()
+		if len(got) != len(tt.issues) {
+			t.Errorf("%q: got issues %q, want %q", tt.src, got, tt.issues)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.issues[i] {
+				t.Errorf("%q: got issues %q, want %q", tt.src, got, tt.issues)
+				break
+			}
+		}
+	}
+
+	root, err := parseScript("f", []byte("state = { id = 4 resources = { oil = x } }"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = parseState(root.Find("state"))
+	if err == nil || err.Error() != `f:1:32: oil: expected number, found "x"` {
+		t.Errorf("got error %v, want invalid resource error", err)
+	}
+}
+
+func TestStateResourceKey(t *testing.T) {
+	oldStates := statesMap
+	defer func() { statesMap = oldStates }()
+	statesMap = map[int]*State{
+		1: {ID: 1, Resources: map[string]float64{"oil": 5}},
+		2: {ID: 2, Resources: map[string]float64{"steel": 0}},
+	}
+
+	tests := []struct {
+		resource string
+		want     string
+		err      string
+	}{
+		{resource: "", want: ""},
+		{resource: "oil", want: "oil"},
+		{resource: "Oil", want: "oil"},
+		{resource: "STEEL", want: "steel"},
+		{resource: "tungsten", err: `no state has resource "tungsten"`},
+	}
+	for _, tt := range tests {
+		got, err := stateResourceKey(tt.resource)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%q: got error %v, want %q", tt.resource, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%q: got %q, %v, want %q", tt.resource, got, err, tt.want)
+		}
+	}
+}
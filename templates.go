@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+)
+
+// BuiltinTemplates are the script templates that can be used by name
+// instead of a template file.
+var builtinTemplates = map[string]string{
+	"geodata":         geoDataTemplate,
+	"state-arrays":    stateArraysTemplate,
+	"state-triggers":  stateTriggersTemplate,
+	"province-arrays": provinceArraysTemplate,
+}
+
+const scriptHeader = `# Autogenerated by hoi4geoparser. Do not mess with the data.
+# evil_c0okie (https://github.com/malashin/hoi4geoparser)
+
+`
+
+// GeoDataTemplate writes impassable_to@ variables and is_impassable flags
+// for every state in an on_startup on_action.
+const geoDataTemplate = scriptHeader + `on_actions = {
+	on_startup = {
+		effect = {
+{{- range .States}}{{if or .ImpassableTo .IsImpassable}}
+			{{.ID}} = {
+{{- range .ImpassableTo}}
+				set_variable = { impassable_to@{{.ID}} = 1 }
+{{- end}}
+{{- if .IsImpassable}}
+				set_state_flag = is_impassable
+{{- end}}
+			}
+{{- end}}{{end}}
+		}
+	}
+}
+`
+
+// StateArraysTemplate fills adjacent_states, connected_states and impassable_states
+// arrays of every state and global arrays of coastal and impassable states
+// in an on_startup on_action.
+const stateArraysTemplate = scriptHeader + `on_actions = {
+	on_startup = {
+		effect = {
+{{- range .States}}{{if or .AdjacentTo .ConnectedTo .ImpassableTo}}
+			{{.ID}} = { # {{.LocalisedName}}
+{{- range .AdjacentTo}}
+				add_to_array = { adjacent_states = {{.ID}} }
+{{- end}}
+{{- range .ConnectedTo}}
+				add_to_array = { connected_states = {{.ID}} }
+{{- end}}
+{{- range .ImpassableTo}}
+				add_to_array = { impassable_states = {{.ID}} }
+{{- end}}
+			}
+{{- end}}{{end}}
+{{- range .States}}{{if .IsCoastal}}
+			add_to_array = { global.coastal_states = {{.ID}} }
+{{- end}}{{end}}
+{{- range .States}}{{if .IsImpassable}}
+			add_to_array = { global.impassable_states = {{.ID}} }
+{{- end}}{{end}}
+		}
+	}
+}
+`
+
+// StateTriggersTemplate writes scripted triggers checking in state scope
+// whether the state is adjacent or impassable to another state,
+// so no variables have to be set on startup. Every trigger is written
+// for every state, with an "always = no" body if the list is empty.
+const stateTriggersTemplate = scriptHeader + `is_impassable_state = {
+{{- $impassable := false}}{{range .States}}{{if .IsImpassable}}{{$impassable = true}}{{end}}{{end}}
+{{- if $impassable}}
+	OR = {
+{{- range .States}}{{if .IsImpassable}}
+		state = {{.ID}}
+{{- end}}{{end}}
+	}
+{{- else}}
+	always = no
+{{- end}}
+}
+{{range .States}}
+# {{.LocalisedName}}
+is_adjacent_to_state_{{.ID}} = {
+{{- if .AdjacentTo}}
+	OR = {
+{{- range .AdjacentTo}}
+		state = {{.ID}}
+{{- end}}
+	}
+{{- else}}
+	always = no
+{{- end}}
+}
+
+is_impassable_to_state_{{.ID}} = {
+{{- if .ImpassableTo}}
+	OR = {
+{{- range .ImpassableTo}}
+		state = {{.ID}}
+{{- end}}
+	}
+{{- else}}
+	always = no
+{{- end}}
+}
+{{end}}`
+
+// ProvinceArraysTemplate fills global.adjacent_provinces_<id>, global.connected_provinces_<id>
+// and global.impassable_provinces_<id> arrays of every selected province
+// in an on_startup on_action.
+const provinceArraysTemplate = scriptHeader + `on_actions = {
+	on_startup = {
+		effect = {
+{{- range $p := .Provinces}}
+{{- range $.FilterProvinces .AdjacentTo}}
+			add_to_array = { global.adjacent_provinces_{{$p.ID}} = {{.ID}} }
+{{- end}}
+{{- range $.FilterProvinces .ConnectedTo}}
+			add_to_array = { global.connected_provinces_{{$p.ID}} = {{.ID}} }
+{{- end}}
+{{- range $.FilterProvinces .ImpassableTo}}
+			add_to_array = { global.impassable_provinces_{{$p.ID}} = {{.ID}} }
+{{- end}}
+{{- end}}
+		}
+	}
+}
+`
+
+// TemplateData is the data the script templates are executed with.
+// Maps are iterated in ID order by the template range action.
+type templateData struct {
+	Date             gameDate
+	Provinces        map[int]*Province // Only provinces of ProvinceTypes.
+	States           map[int]*State
+	StrategicRegions map[int]*StrategicRegion
+	ProvinceTypes    map[string]bool // Selected province types, all types if empty.
+}
+
+// FilterProvinces returns the provinces of m that have one of the selected types,
+// for example {{range $.FilterProvinces .AdjacentTo}}.
+func (d templateData) FilterProvinces(m map[int]*Province) map[int]*Province {
+	if len(d.ProvinceTypes) == 0 {
+		return m
+	}
+	filtered := make(map[int]*Province)
+	for id, p := range m {
+		if d.ProvinceTypes[p.Type] {
+			filtered[id] = p
+		}
+	}
+	return filtered
+}
+
+var templateFuncs = template.FuncMap{
+	"join":  strings.Join,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// LoadTemplate returns the built-in template with the given name
+// or parses the template file at that path.
+func loadTemplate(name string) (*template.Template, error) {
+	text, ok := builtinTemplates[name]
+	if !ok {
+		b, err := ioutil.ReadFile(filepath.FromSlash(name))
+		if err != nil {
+			return nil, err
+		}
+		text = string(b)
+	}
+	return template.New(filepath.Base(name)).Funcs(templateFuncs).Parse(text)
+}
+
+// SaveScript executes a script template with the parsed data
+// and writes the result into fileName inside the output folder.
+// Provinces are limited to provinceTypes unless it is empty.
+func saveScript(templateName, fileName string, provinceTypes []string) error {
+	t, err := loadTemplate(templateName)
+	if err != nil {
+		return err
+	}
+
+	// Localisation files are only read for templates that use the names.
+	if usesField(t, "LocalisedName") {
+		err = localiseNames()
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("%s: Writing '%v' with '%v' template...\n", time.Since(startTime), fileName, templateName)
+	path := filepath.Join(outputPath, filepath.FromSlash(fileName))
+	err = os.MkdirAll(filepath.Dir(path), 0775)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data := templateData{
+		Date:             startDate,
+		States:           statesMap,
+		StrategicRegions: strategicRegionMap,
+		ProvinceTypes:    make(map[string]bool),
+	}
+	for _, t := range provinceTypes {
+		data.ProvinceTypes[t] = true
+	}
+	data.Provinces = data.FilterProvinces(provincesIDMap)
+	err = t.Execute(f, data)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: Saved '%v'\n", time.Since(startTime), fileName)
+	return nil
+}
+
+// UsesField reports whether any template defined in t accesses a field
+// or method with the given name, like {{.LocalisedName}}.
+func usesField(t *template.Template, name string) bool {
+	for _, tmpl := range t.Templates() {
+		if tmpl.Tree != nil && nodeUsesField(tmpl.Tree.Root, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func nodeUsesField(n parse.Node, name string) bool {
+	var children []parse.Node
+	var idents []string
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		children = n.Nodes
+	case *parse.ActionNode:
+		children = []parse.Node{n.Pipe}
+	case *parse.IfNode:
+		children = []parse.Node{n.Pipe, n.List, n.ElseList}
+	case *parse.RangeNode:
+		children = []parse.Node{n.Pipe, n.List, n.ElseList}
+	case *parse.WithNode:
+		children = []parse.Node{n.Pipe, n.List, n.ElseList}
+	case *parse.TemplateNode:
+		children = []parse.Node{n.Pipe}
+	case *parse.PipeNode:
+		if n == nil {
+			return false
+		}
+		for _, c := range n.Cmds {
+			children = append(children, c)
+		}
+	case *parse.CommandNode:
+		children = n.Args
+	case *parse.ChainNode:
+		children = []parse.Node{n.Node}
+		idents = n.Field
+	case *parse.FieldNode:
+		idents = n.Ident
+	case *parse.VariableNode:
+		idents = n.Ident[1:]
+	}
+
+	for _, ident := range idents {
+		if ident == name {
+			return true
+		}
+	}
+	for _, c := range children {
+		if c != nil && nodeUsesField(c, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"text/template"
+)
+
+// TestTemplateData returns states 1 and 2 adjacent and connected to each other,
+// state 3 impassable to state 1 and land provinces 1 and 2 next to sea province 3.
+func testTemplateData() templateData {
+	s1 := &State{ID: 1, LocalisedName: "One", IsCoastal: true}
+	s2 := &State{ID: 2, LocalisedName: "Two"}
+	s3 := &State{ID: 3, LocalisedName: "Three", IsImpassable: true}
+	s1.AdjacentTo = map[int]*State{2: s2}
+	s1.ConnectedTo = map[int]*State{2: s2}
+	s1.ImpassableTo = map[int]*State{3: s3}
+	s2.AdjacentTo = map[int]*State{1: s1}
+	s2.ConnectedTo = map[int]*State{1: s1}
+	s3.ImpassableTo = map[int]*State{1: s1}
+
+	p1 := &Province{ID: 1, Type: "land"}
+	p2 := &Province{ID: 2, Type: "land"}
+	p3 := &Province{ID: 3, Type: "sea"}
+	p1.AdjacentTo = map[int]*Province{2: p2, 3: p3}
+	p1.ConnectedTo = map[int]*Province{2: p2}
+	p2.AdjacentTo = map[int]*Province{1: p1}
+	p2.ConnectedTo = map[int]*Province{1: p1}
+	p2.ImpassableTo = map[int]*Province{3: p3}
+	p3.AdjacentTo = map[int]*Province{1: p1}
+
+	return templateData{
+		Date:      startDate,
+		Provinces: map[int]*Province{1: p1, 2: p2, 3: p3},
+		States:    map[int]*State{1: s1, 2: s2, 3: s3},
+	}
+}
+
+// ExecuteTestTemplate executes the built-in template with data
+// and returns the result without the script header.
+func executeTestTemplate(t *testing.T, name string, data templateData) string {
+	tmpl, err := loadTemplate(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sb strings.Builder
+	err = tmpl.Execute(&sb, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sb.String(), scriptHeader) {
+		t.Fatalf("%v: missing script header", name)
+	}
+	return strings.TrimPrefix(sb.String(), scriptHeader)
+}
+
+func TestGeoDataTemplate(t *testing.T) {
+	data := testTemplateData()
+	got := executeTestTemplate(t, "geodata", data)
+	want := `on_actions = {
+	on_startup = {
+		effect = {
+			1 = {
+				set_variable = { impassable_to@3 = 1 }
+			}
+			3 = {
+				set_variable = { impassable_to@1 = 1 }
+				set_state_flag = is_impassable
+			}
+		}
+	}
+}
+`
+	if got != want {
+		t.Errorf("got:\n%v\nwant:\n%v", got, want)
+	}
+
+	data.States = nil
+	got = executeTestTemplate(t, "geodata", data)
+	want = "on_actions = {\n\ton_startup = {\n\t\teffect = {\n\t\t}\n\t}\n}\n"
+	if got != want {
+		t.Errorf("no states: got:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestUsesField(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"{{.ID}}", false},
+		{"{{.LocalisedName}}", true},
+		{"{{range .States}}{{.LocalisedName}}{{end}}", true},
+		{"{{range $s := .States}}{{$s.LocalisedName}}{{end}}", true},
+		{"{{range .States}}{{if .IsCoastal}}{{else}}{{lower .LocalisedName}}{{end}}{{end}}", true},
+		{"{{with .States}}{{(index . 1).LocalisedName}}{{end}}", true},
+		{`{{define "name"}}{{.LocalisedName}}{{end}}{{template "name" .}}`, true},
+		{"{{range .States}}{{.Name}} LocalisedName{{end}}", false},
+	}
+	for _, tt := range tests {
+		tmpl, err := template.New("t").Funcs(templateFuncs).Parse(tt.text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := usesField(tmpl, "LocalisedName"); got != tt.want {
+			t.Errorf("%q: got %v, want %v", tt.text, got, tt.want)
+		}
+	}
+
+	for name := range builtinTemplates {
+		tmpl, err := loadTemplate(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := name == "state-arrays" || name == "state-triggers"
+		if got := usesField(tmpl, "LocalisedName"); got != want {
+			t.Errorf("%v: got %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestStateArraysTemplate(t *testing.T) {
+	got := executeTestTemplate(t, "state-arrays", testTemplateData())
+	want := `on_actions = {
+	on_startup = {
+		effect = {
+			1 = { # One
+				add_to_array = { adjacent_states = 2 }
+				add_to_array = { connected_states = 2 }
+				add_to_array = { impassable_states = 3 }
+			}
+			2 = { # Two
+				add_to_array = { adjacent_states = 1 }
+				add_to_array = { connected_states = 1 }
+			}
+			3 = { # Three
+				add_to_array = { impassable_states = 1 }
+			}
+			add_to_array = { global.coastal_states = 1 }
+			add_to_array = { global.impassable_states = 3 }
+		}
+	}
+}
+`
+	if got != want {
+		t.Errorf("got:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestStateTriggersTemplate(t *testing.T) {
+	data := testTemplateData()
+	got := executeTestTemplate(t, "state-triggers", data)
+	want := `is_impassable_state = {
+	OR = {
+		state = 3
+	}
+}
+
+# One
+is_adjacent_to_state_1 = {
+	OR = {
+		state = 2
+	}
+}
+
+is_impassable_to_state_1 = {
+	OR = {
+		state = 3
+	}
+}
+
+# Two
+is_adjacent_to_state_2 = {
+	OR = {
+		state = 1
+	}
+}
+
+is_impassable_to_state_2 = {
+	always = no
+}
+
+# Three
+is_adjacent_to_state_3 = {
+	always = no
+}
+
+is_impassable_to_state_3 = {
+	OR = {
+		state = 1
+	}
+}
+`
+	if got != want {
+		t.Errorf("got:\n%v\nwant:\n%v", got, want)
+	}
+
+	// Triggers are still defined without any impassable state.
+	data.States = map[int]*State{2: data.States[2]}
+	data.States[2].AdjacentTo = nil
+	got = executeTestTemplate(t, "state-triggers", data)
+	want = `is_impassable_state = {
+	always = no
+}
+
+# Two
+is_adjacent_to_state_2 = {
+	always = no
+}
+
+is_impassable_to_state_2 = {
+	always = no
+}
+`
+	if got != want {
+		t.Errorf("no impassable states: got:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestProvinceArraysTemplate(t *testing.T) {
+	tests := []struct {
+		types []string
+		want  string
+	}{
+		{
+			want: `on_actions = {
+	on_startup = {
+		effect = {
+			add_to_array = { global.adjacent_provinces_1 = 2 }
+			add_to_array = { global.adjacent_provinces_1 = 3 }
+			add_to_array = { global.connected_provinces_1 = 2 }
+			add_to_array = { global.adjacent_provinces_2 = 1 }
+			add_to_array = { global.connected_provinces_2 = 1 }
+			add_to_array = { global.impassable_provinces_2 = 3 }
+			add_to_array = { global.adjacent_provinces_3 = 1 }
+		}
+	}
+}
+`,
+		},
+		{
+			types: []string{"land"},
+			want: `on_actions = {
+	on_startup = {
+		effect = {
+			add_to_array = { global.adjacent_provinces_1 = 2 }
+			add_to_array = { global.connected_provinces_1 = 2 }
+			add_to_array = { global.adjacent_provinces_2 = 1 }
+			add_to_array = { global.connected_provinces_2 = 1 }
+		}
+	}
+}
+`,
+		},
+		{
+			types: []string{"sea", "lake"},
+			want:  "on_actions = {\n\ton_startup = {\n\t\teffect = {\n\t\t}\n\t}\n}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		data := testTemplateData()
+		data.ProvinceTypes = make(map[string]bool)
+		for _, typ := range tt.types {
+			data.ProvinceTypes[typ] = true
+		}
+		data.Provinces = data.FilterProvinces(data.Provinces)
+		if got := executeTestTemplate(t, "province-arrays", data); got != tt.want {
+			t.Errorf("%v: got:\n%v\nwant:\n%v", tt.types, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"time"
+
+	bmp "github.com/jsummers/gobmp"
+)
+
+// TerrainCategory represents an in-game terrain category like "plains" or "ocean".
+type TerrainCategory struct {
+	Name    string
+	IsWater bool
+}
+
+var terrainCategoriesMap = make(map[string]*TerrainCategory)
+
+// TerrainIndexMap maps terrain.bmp palette indices to terrain categories.
+var terrainIndexMap = make(map[uint8]string)
+var terrainLoaded bool
+
+// LoadTerrainFiles parses the terrain files on first use,
+// so several commands share the categories and their issues.
+func loadTerrainFiles() error {
+	if terrainLoaded {
+		return nil
+	}
+	err := parseTerrainFiles()
+	if err != nil {
+		return err
+	}
+	terrainLoaded = true
+	return nil
+}
+
+func parseTerrainFiles() error {
+	fmt.Printf("%s: Parsing terrain files...\n", time.Since(startTime))
+	terrainFiles, err := globInputFiles(terrainCategoriesPath, "common/terrain", "*.txt")
+	if err != nil {
+		return err
+	}
+	if len(terrainFiles) == 0 {
+		fmt.Printf("%s: No terrain files found, province terrain is not checked\n", time.Since(startTime))
+	}
+	for _, path := range terrainFiles {
+		// Broken files are skipped in lenient mode.
+		err = parseTerrainFile(path)
+		if err != nil {
+			err = lenientError("definitions", err)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func parseTerrainFile(path string) error {
+	root, err := parseScriptFile(path)
+	if err != nil {
+		return err
+	}
+	for _, categories := range root.FindAll("categories") {
+		if !categories.IsBlock {
+			return newFileError(categories.Pos, "categories: expected block")
+		}
+		for _, n := range categories.Children {
+			category, err := parseTerrainCategory(n)
+			if err != nil {
+				return err
+			}
+			terrainCategoriesMap[category.Name] = &category
+		}
+	}
+	for _, graphical := range root.FindAll("graphical_terrain") {
+		if !graphical.IsBlock {
+			return newFileError(graphical.Pos, "graphical_terrain: expected block")
+		}
+		for _, n := range graphical.Children {
+			err = parseGraphicalTerrain(n)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ParseGraphicalTerrain reads the palette index and the category
+// of a terrain.bmp entry like "plains_0 = { type = plains color = { 0 } }".
+func parseGraphicalTerrain(n *scriptNode) error {
+	if !n.IsBlock {
+		return newFileError(n.Pos, "%v: expected block", n.Key)
+	}
+	t := n.Find("type")
+	c := n.Find("color")
+	if t == nil || c == nil {
+		return nil
+	}
+	indices, err := c.Ints()
+	if err != nil {
+		return err
+	}
+	if len(indices) != 1 || indices[0] < 0 || indices[0] > 255 {
+		return newFileError(c.Pos, "%v: expected a single palette index", n.Key)
+	}
+	terrainIndexMap[uint8(indices[0])] = t.Value
+	return nil
+}
+
+// LoadTerrainImage decodes terrain.bmp, which has to be an 8-bit paletted image.
+func loadTerrainImage() (*image.Paletted, error) {
+	terrainFile, err := os.Open(filepath.FromSlash(terrainPath))
+	if err != nil {
+		return nil, err
+	}
+	defer terrainFile.Close()
+	terrainImage, err := bmp.Decode(terrainFile)
+	if err != nil {
+		return nil, err
+	}
+	paletted, ok := terrainImage.(*image.Paletted)
+	if !ok {
+		return nil, errors.New("\"" + terrainPath + "\": expected an 8-bit paletted image")
+	}
+	return paletted, nil
+}
+
+// DominantTerrain returns the terrain category of most pixels of the province
+// in terrain.bmp, or an empty string if none of its palette indices are known.
+func dominantTerrain(p *Province, terrainImage *image.Paletted) string {
+	counts := make(map[string]int)
+	for _, pc := range p.PixelCoords {
+		if t, ok := terrainIndexMap[terrainImage.ColorIndexAt(pc.X, pc.Y)]; ok {
+			counts[t]++
+		}
+	}
+	dominant := ""
+	for t, n := range counts {
+		if n > counts[dominant] || (n == counts[dominant] && t < dominant) {
+			dominant = t
+		}
+	}
+	return dominant
+}
+
+// IsCoastalProvince reports whether a land province touches a sea province
+// or a sea province touches a land province. Lakes are never coastal.
+func isCoastalProvince(p *Province) bool {
+	for _, a := range p.AdjacentTo {
+		if (p.Type == "land" && a.Type == "sea") || (p.Type == "sea" && a.Type == "land") {
+			return true
+		}
+	}
+	return false
+}
+
+func parseTerrainCategory(n *scriptNode) (category TerrainCategory, err error) {
+	if !n.IsBlock {
+		return category, newFileError(n.Pos, "%v: expected block", n.Key)
+	}
+	category.Name = n.Key
+	if v := n.Find("is_water"); v != nil {
+		category.IsWater, err = v.Bool()
+		if err != nil {
+			return category, err
+		}
+	}
+	return category, nil
+}
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+// ValidationIssue represents a single problem found in the map files.
+type validationIssue struct {
+	Check   string        // Name of the validate command that reports the issue.
+	Pos     filePos       // Position in the file, Line is zero for image issues.
+	Points  []image.Point // Affected pixels.
+	IDs     []int         // Affected province IDs.
+	Message string
+}
+
+func (i validationIssue) String() string {
+	var sb strings.Builder
+	if i.Pos.Line > 0 {
+		sb.WriteString(i.Pos.String() + ": ")
+	} else if i.Pos.Path != "" {
+		sb.WriteString(i.Pos.Path + ": ")
+	}
+	sb.WriteString(i.Message)
+	if len(i.IDs) > 0 {
+		sb.WriteString(" [provinces " + joinInts(i.IDs) + "]")
+	}
+	if len(i.Points) > 0 {
+		// Long pixel lists are cut, the map shows all of them.
+		const maxPoints = 10
+		points := make([]string, 0, maxPoints)
+		for j, p := range i.Points {
+			if j == maxPoints {
+				points = append(points, fmt.Sprintf("and %v more", len(i.Points)-maxPoints))
+				break
+			}
+			points = append(points, fmt.Sprintf("(%v,%v)", p.X, p.Y))
+		}
+		sb.WriteString(" at " + strings.Join(points, ", "))
+	}
+	return sb.String()
+}
+
+// LenientParsing is set when only validate commands are selected.
+// Parse functions then record errors as validation issues and skip
+// the broken entries, so every problem is reported in a single run.
+var lenientParsing bool
+var validationIssues []validationIssue
+
+func addValidationIssue(issue validationIssue) {
+	validationIssues = append(validationIssues, issue)
+}
+
+// ReportIssue records the issue in lenient mode
+// and returns it as an error otherwise.
+func reportIssue(issue validationIssue) error {
+	if !lenientParsing {
+		if issue.Pos.Line > 0 {
+			details := issue
+			details.Pos = filePos{}
+			return newFileError(issue.Pos, "%v", details)
+		}
+		return errors.New(issue.String())
+	}
+	addValidationIssue(issue)
+	return nil
+}
+
+// LenientError records err as an issue of the check in lenient mode
+// and returns nil, otherwise it returns err unchanged.
+func lenientError(check string, err error) error {
+	if !lenientParsing {
+		return err
+	}
+	issue := validationIssue{Check: check, Message: err.Error()}
+	if fe, ok := err.(*fileError); ok {
+		issue.Pos = fe.Pos
+		issue.Message = fe.Msg
+	}
+	addValidationIssue(issue)
+	return nil
+}
+
+// IssuesOf returns the issues recorded for the check while parsing.
+func issuesOf(check string) (issues []validationIssue) {
+	for _, i := range validationIssues {
+		if i.Check == check {
+			issues = append(issues, i)
+		}
+	}
+	return issues
+}
+
+func validateDefinitions(fileName string, render bool) error {
+	fmt.Printf("%s: Validating definition.csv...\n", time.Since(startTime))
+	// Broken terrain files are reported with the definitions.
+	err := loadTerrainFiles()
+	if err != nil {
+		return err
+	}
+	issues := issuesOf("definitions")
+
+	for _, id := range sortedKeySliceFromProvinceMap(provincesIDMap) {
+		p := provincesIDMap[id]
+		pos := filePos{Path: definitionsPath, Line: p.Line}
+
+		// The first line is a placeholder for the map edges.
+		if p.ID == 0 {
+			continue
+		}
+		if len(p.PixelCoords) == 0 {
+			issues = append(issues, validationIssue{Check: "definitions", Pos: pos, IDs: []int{p.ID}, Message: fmt.Sprintf("province %v has no pixels in provinces.bmp", p.ID)})
+		}
+		if p.Type != "land" && p.Type != "sea" && p.Type != "lake" {
+			issues = append(issues, validationIssue{Check: "definitions", Pos: pos, IDs: []int{p.ID}, Points: p.PixelCoords, Message: fmt.Sprintf("province %v has invalid type %q", p.ID, p.Type)})
+		}
+		if len(terrainCategoriesMap) > 0 && terrainCategoriesMap[p.Terrain] == nil {
+			issues = append(issues, validationIssue{Check: "definitions", Pos: pos, IDs: []int{p.ID}, Points: p.PixelCoords, Message: fmt.Sprintf("province %v has unknown terrain %q", p.ID, p.Terrain)})
+		}
+	}
+
+	return saveValidationIssues("definitions", fileName, render, issues)
+}
+
+func validatePixels(fileName string, render bool) error {
+	fmt.Printf("%s: Validating provinces.bmp pixels...\n", time.Since(startTime))
+	var issues []validationIssue
+
+	// Look up the province of every pixel once,
+	// pixels without a definition are nil.
+	w, h := provincesImageSize.Dx(), provincesImageSize.Dy()
+	grid := make([]*Province, w*h)
+	for _, p := range provincesIDMap {
+		for _, c := range p.PixelCoords {
+			grid[c.Y*w+c.X] = p
+		}
+	}
+	at := func(x, y int) *Province {
+		if x < 0 || y < 0 || x >= w || y >= h {
+			return nil
+		}
+		return grid[y*w+x]
+	}
+
+	// Diagonal contacts grouped by province pair.
+	type provincePair struct{ a, b *Province }
+	var diagonalPairs []provincePair
+	diagonalPoints := make(map[provincePair][]image.Point)
+	addDiagonal := func(a, b *Province, pt image.Point) {
+		if a == nil || b == nil || a == b || a.AdjacentTo[b.ID] != nil {
+			return
+		}
+		if a.ID > b.ID {
+			a, b = b, a
+		}
+		pair := provincePair{a, b}
+		if _, ok := diagonalPoints[pair]; !ok {
+			diagonalPairs = append(diagonalPairs, pair)
+		}
+		diagonalPoints[pair] = append(diagonalPoints[pair], pt)
+	}
+
+	for y := 0; y < h-1; y++ {
+		for x := 0; x < w-1; x++ {
+			tl, tr, bl, br := at(x, y), at(x+1, y), at(x, y+1), at(x+1, y+1)
+			if tl == nil || tr == nil || bl == nil || br == nil {
+				continue
+			}
+			pt := image.Point{x, y}
+
+			// Four provinces meeting in a single point.
+			if tl != tr && tl != bl && tl != br && tr != bl && tr != br && bl != br {
+				issues = append(issues, validationIssue{Check: "pixels", Points: []image.Point{pt, {x + 1, y}, {x, y + 1}, {x + 1, y + 1}}, IDs: []int{tl.ID, tr.ID, bl.ID, br.ID}, Message: "x-crossing of four provinces"})
+			}
+
+			// Two provinces crossing each other in a checker pattern.
+			if tl == br && tr == bl && tl != tr {
+				issues = append(issues, validationIssue{Check: "pixels", Points: []image.Point{pt, {x + 1, y}, {x, y + 1}, {x + 1, y + 1}}, IDs: []int{tl.ID, tr.ID}, Message: fmt.Sprintf("x-crossing of provinces %v and %v", tl.ID, tr.ID)})
+			}
+
+			// Provinces that only touch through a corner.
+			addDiagonal(tl, br, pt)
+			addDiagonal(tr, bl, image.Point{x + 1, y})
+		}
+	}
+
+	for _, pair := range diagonalPairs {
+		issues = append(issues, validationIssue{Check: "pixels", Points: diagonalPoints[pair], IDs: []int{pair.a.ID, pair.b.ID}, Message: fmt.Sprintf("provinces %v and %v only touch diagonally", pair.a.ID, pair.b.ID)})
+	}
+
+	// Pixels without a single neighbor of the same province.
+	for _, id := range sortedKeySliceFromProvinceMap(provincesIDMap) {
+		p := provincesIDMap[id]
+		var islands []image.Point
+		for _, c := range p.PixelCoords {
+			if at(c.X-1, c.Y) != p && at(c.X+1, c.Y) != p && at(c.X, c.Y-1) != p && at(c.X, c.Y+1) != p {
+				islands = append(islands, c)
+			}
+		}
+		if len(islands) > 0 {
+			issues = append(issues, validationIssue{Check: "pixels", Points: islands, IDs: []int{p.ID}, Message: fmt.Sprintf("province %v has single-pixel islands", p.ID)})
+		}
+	}
+
+	return saveValidationIssues("pixels", fileName, render, issues)
+}
+
+func validateContiguity(fileName string, render bool) error {
+	fmt.Printf("%s: Validating province and state contiguity...\n", time.Since(startTime))
+	var issues []validationIssue
+
+	for _, id := range sortedKeySliceFromProvinceMap(provincesIDMap) {
+		p := provincesIDMap[id]
+		fragments := findFragments(p.PixelCoords, p.PixelCoordsMap)
+		if len(fragments) > 1 {
+			issues = append(issues, newFragmentsIssue(fmt.Sprintf("province %v", p.ID), []int{p.ID}, fragments))
+		}
+	}
+
+	for _, id := range sortedKeySliceFromStateMap(statesMap) {
+		s := statesMap[id]
+		fragments := findFragments(s.PixelCoords, s.PixelCoordsMap)
+		if len(fragments) > 1 {
+			issues = append(issues, newFragmentsIssue(fmt.Sprintf("state %v", s.ID), nil, fragments))
+		}
+	}
+
+	return saveValidationIssues("contiguity", fileName, render, issues)
+}
+
+func validateAssignments(fileName string, render bool) error {
+	fmt.Printf("%s: Validating state and strategic region provinces...\n", time.Since(startTime))
+	issues := issuesOf("assignments")
+
+	// Find every state and strategic region listing each province.
+	provinceStates := make(map[int][]*State)
+	for _, id := range sortedKeySliceFromStateMap(statesMap) {
+		s := statesMap[id]
+		continents := make(map[int]bool)
+		var landIDs []int
+		for _, pID := range sortedKeySliceFromProvinceMap(s.Provinces) {
+			p := s.Provinces[pID]
+			provinceStates[pID] = append(provinceStates[pID], s)
+			if p.Type == "sea" {
+				issues = append(issues, validationIssue{Check: "assignments", Pos: s.Pos, IDs: []int{pID}, Message: fmt.Sprintf("state %v contains sea province %v", s.ID, pID)})
+			}
+			if p.Type == "land" {
+				continents[p.Continent] = true
+				landIDs = append(landIDs, pID)
+			}
+		}
+		if len(continents) > 1 {
+			issues = append(issues, validationIssue{Check: "assignments", Pos: s.Pos, IDs: landIDs, Message: fmt.Sprintf("state %v has provinces on continents %v", s.ID, joinInts(sortedKeySliceFromBoolMap(continents)))})
+		}
+	}
+	provinceRegions := make(map[int][]*StrategicRegion)
+	for _, id := range sortedKeySliceFromStrategicRegionMap(strategicRegionMap) {
+		r := strategicRegionMap[id]
+		for pID := range r.Provinces {
+			provinceRegions[pID] = append(provinceRegions[pID], r)
+		}
+	}
+
+	for _, id := range sortedKeySliceFromProvinceMap(provincesIDMap) {
+		p := provincesIDMap[id]
+		// The first line is a placeholder for the map edges.
+		if p.ID == 0 {
+			continue
+		}
+		pos := filePos{Path: definitionsPath, Line: p.Line}
+
+		states := provinceStates[p.ID]
+		switch {
+		case len(states) == 0 && p.Type == "land":
+			issues = append(issues, validationIssue{Check: "assignments", Pos: pos, IDs: []int{p.ID}, Message: fmt.Sprintf("land province %v is not in any state", p.ID)})
+		case len(states) > 1:
+			refs := make([]string, len(states))
+			for i, s := range states {
+				refs[i] = fmt.Sprintf("%v (%v)", s.ID, s.Pos)
+			}
+			issues = append(issues, validationIssue{Check: "assignments", Pos: states[1].Pos, IDs: []int{p.ID}, Message: fmt.Sprintf("province %v is in several states: %v", p.ID, strings.Join(refs, ", "))})
+		}
+
+		regions := provinceRegions[p.ID]
+		switch {
+		case len(regions) == 0:
+			issues = append(issues, validationIssue{Check: "assignments", Pos: pos, IDs: []int{p.ID}, Message: fmt.Sprintf("%v province %v is not in any strategic region", p.Type, p.ID)})
+		case len(regions) > 1:
+			refs := make([]string, len(regions))
+			for i, r := range regions {
+				refs[i] = fmt.Sprintf("%v (%v)", r.ID, r.Pos)
+			}
+			issues = append(issues, validationIssue{Check: "assignments", Pos: regions[1].Pos, IDs: []int{p.ID}, Message: fmt.Sprintf("province %v is in several strategic regions: %v", p.ID, strings.Join(refs, ", "))})
+		}
+	}
+
+	return saveValidationIssues("assignments", fileName, render, issues)
+}
+
+// ValidateTerrain compares the coastal flags and terrain of definition.csv
+// with the ones found in provinces.bmp and terrain.bmp. With fix set
+// it writes definition.csv with the found values into the output folder.
+func validateTerrain(fileName string, render, fix bool) error {
+	fmt.Printf("%s: Validating coastal flags and terrain...\n", time.Since(startTime))
+	var issues []validationIssue
+
+	err := loadTerrainFiles()
+	if err != nil {
+		return err
+	}
+	var terrainImage *image.Paletted
+	if len(terrainIndexMap) == 0 && len(terrainCategoriesMap) > 0 {
+		fmt.Printf("%s: No graphical_terrain found, province terrain is not checked\n", time.Since(startTime))
+	} else if len(terrainIndexMap) > 0 {
+		terrainImage, err = loadTerrainImage()
+		if err != nil {
+			return err
+		}
+	}
+
+	var fixed []Province
+	for _, id := range sortedKeySliceFromProvinceMap(provincesIDMap) {
+		p := *provincesIDMap[id]
+		pos := filePos{Path: definitionsPath, Line: p.Line}
+
+		// The first line is a placeholder for the map edges.
+		if p.ID != 0 {
+			coastal := isCoastalProvince(&p)
+			if coastal != p.IsCoastal {
+				issues = append(issues, validationIssue{Check: "terrain", Pos: pos, IDs: []int{p.ID}, Message: fmt.Sprintf("%v province %v is coastal %v, found %v", p.Type, p.ID, p.IsCoastal, coastal)})
+				p.IsCoastal = coastal
+			}
+
+			if terrainImage != nil && p.Type == "land" {
+				terrain := dominantTerrain(&p, terrainImage)
+				if terrain != "" && terrain != p.Terrain {
+					issues = append(issues, validationIssue{Check: "terrain", Pos: pos, IDs: []int{p.ID}, Message: fmt.Sprintf("province %v has terrain %v, found %v", p.ID, p.Terrain, terrain)})
+					p.Terrain = terrain
+				}
+			}
+		}
+		fixed = append(fixed, p)
+	}
+
+	err = saveValidationIssues("terrain", fileName, render, issues)
+	if err != nil || !fix {
+		return err
+	}
+	return saveDefinitions("definition.csv", fixed, false)
+}
+
+func joinInts(ints []int) string {
+	s := make([]string, len(ints))
+	for i, n := range ints {
+		s[i] = fmt.Sprint(n)
+	}
+	return strings.Join(s, ", ")
+}
+
+// NewFragmentsIssue describes every fragment of a split area.
+// The pixels of all fragments but the largest one are highlighted.
+func newFragmentsIssue(name string, ids []int, fragments [][]image.Point) validationIssue {
+	issue := validationIssue{Check: "contiguity", IDs: ids}
+	parts := make([]string, len(fragments))
+	for i, f := range fragments {
+		r := boundingBox(f)
+		parts[i] = fmt.Sprintf("%v px in (%v,%v)-(%v,%v)", len(f), r.Min.X, r.Min.Y, r.Max.X-1, r.Max.Y-1)
+		if i > 0 {
+			issue.Points = append(issue.Points, f...)
+		}
+	}
+	issue.Message = fmt.Sprintf("%v is split into %v parts: %v", name, len(fragments), strings.Join(parts, "; "))
+	return issue
+}
+
+// FindFragments splits coords into 4-connected parts, largest first.
+func findFragments(coords []image.Point, coordsMap map[image.Point]bool) (fragments [][]image.Point) {
+	visited := make(map[image.Point]bool, len(coords))
+	for _, start := range coords {
+		if visited[start] {
+			continue
+		}
+		visited[start] = true
+		fragment := []image.Point{start}
+		for i := 0; i < len(fragment); i++ {
+			c := fragment[i]
+			for _, n := range [...]image.Point{{c.X + 1, c.Y}, {c.X - 1, c.Y}, {c.X, c.Y + 1}, {c.X, c.Y - 1}} {
+				if coordsMap[n] && !visited[n] {
+					visited[n] = true
+					fragment = append(fragment, n)
+				}
+			}
+		}
+		fragments = append(fragments, fragment)
+	}
+	sort.SliceStable(fragments, func(i, j int) bool { return len(fragments[i]) > len(fragments[j]) })
+	return fragments
+}
+
+// SaveValidationIssues prints the issues, writes them into fileName
+// and draws them on a map next to it if render is set.
+func saveValidationIssues(check, fileName string, render bool, issues []validationIssue) error {
+	for _, i := range issues {
+		fmt.Println(i)
+	}
+	fmt.Printf("%s: Found %v %v issues\n", time.Since(startTime), len(issues), check)
+
+	path := filepath.Join(outputPath, fileName)
+	err := os.MkdirAll(filepath.Dir(path), 0775)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for _, i := range issues {
+		if _, err = fmt.Fprintln(f, i); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("%s: Saved '%v'\n", time.Since(startTime), fileName)
+
+	if !render {
+		return nil
+	}
+	return generateValidationMap(strings.TrimSuffix(fileName, filepath.Ext(fileName))+".png", issues)
+}
+
+// GenerateValidationMap draws the affected provinces in light red
+// and the affected pixels in red, with a frame around small pixel groups.
+func generateValidationMap(fileName string, issues []validationIssue) error {
+	fmt.Printf("%s: Generating validation map...\n", time.Since(startTime))
+
+	// Create empty image and fill it with blue color (water).
+	img := image.NewRGBA(provincesImageSize)
+	draw.Draw(img, img.Bounds(), &image.Uniform{waterColor}, image.ZP, draw.Src)
+
+	// Draw land province shapes.
+	landCol := color.RGBA{255, 255, 255, 255}
+	for _, prov := range provincesIDMap {
+		if prov.Type == "land" {
+			for _, p := range prov.PixelCoords {
+				img.Set(p.X, p.Y, landCol)
+			}
+		}
+	}
+
+	// Draw affected provinces.
+	provinceCol := color.RGBA{255, 190, 190, 255}
+	ids := make(map[int]bool)
+	for _, i := range issues {
+		for _, id := range i.IDs {
+			ids[id] = true
+		}
+	}
+	for id := range ids {
+		if prov, ok := provincesIDMap[id]; ok {
+			for _, p := range prov.PixelCoords {
+				img.Set(p.X, p.Y, provinceCol)
+			}
+		}
+	}
+
+	// Draw affected pixels.
+	pixelCol := color.RGBA{255, 0, 0, 255}
+	for _, i := range issues {
+		for _, p := range i.Points {
+			img.Set(p.X, p.Y, pixelCol)
+		}
+		// Single pixels are invisible on a full map without a frame.
+		if len(i.Points) > 0 && len(i.Points) <= 4 {
+			r := boundingBox(i.Points).Inset(-4)
+			for x := r.Min.X; x < r.Max.X; x++ {
+				img.Set(x, r.Min.Y, pixelCol)
+				img.Set(x, r.Max.Y-1, pixelCol)
+			}
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				img.Set(r.Min.X, y, pixelCol)
+				img.Set(r.Max.X-1, y, pixelCol)
+			}
+		}
+	}
+
+	// Save image as PNG.
+	out, err := os.Create(filepath.Join(outputPath, fileName))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	err = png.Encode(out, img)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: Saved '%v'\n", time.Since(startTime), fileName)
+	return nil
+}
@@ -0,0 +1,615 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	bmp "github.com/jsummers/gobmp"
+)
+
+// SetupValidationTest replaces the parsed map data with provinces drawn by rows
+// of province IDs, one digit per pixel and "." for pixels without a province.
+// Provinces are plains land on continent 1 unless types has another type for them.
+// Province n is defined on line n of definition.csv. Terrain files are read
+// from an empty folder and reports are written into a temporary folder.
+func setupValidationTest(t *testing.T, types map[int]string, rows ...string) {
+	oldProvinces, oldStates, oldRegions := provincesIDMap, statesMap, strategicRegionMap
+	oldCategories, oldIndices, oldTerrainPath, oldTerrainLoaded := terrainCategoriesMap, terrainIndexMap, terrainCategoriesPath, terrainLoaded
+	oldSize, oldOutput, oldDefinitions, oldLenient := provincesImageSize, outputPath, definitionsPath, lenientParsing
+	t.Cleanup(func() {
+		provincesIDMap, statesMap, strategicRegionMap = oldProvinces, oldStates, oldRegions
+		terrainCategoriesMap, terrainIndexMap, terrainCategoriesPath, terrainLoaded = oldCategories, oldIndices, oldTerrainPath, oldTerrainLoaded
+		provincesImageSize, outputPath, definitionsPath, lenientParsing = oldSize, oldOutput, oldDefinitions, oldLenient
+		validationIssues = nil
+	})
+
+	provincesIDMap = make(map[int]*Province)
+	statesMap = make(map[int]*State)
+	strategicRegionMap = make(map[int]*StrategicRegion)
+	terrainCategoriesMap = map[string]*TerrainCategory{"plains": {Name: "plains"}, "ocean": {Name: "ocean", IsWater: true}}
+	terrainIndexMap = make(map[uint8]string)
+	terrainCategoriesPath = t.TempDir()
+	terrainLoaded = true
+	outputPath = t.TempDir()
+	definitionsPath = "definition.csv"
+	lenientParsing = true
+	validationIssues = nil
+
+	province := func(id int) *Province {
+		if p, ok := provincesIDMap[id]; ok {
+			return p
+		}
+		p := &Province{
+			ID:             id,
+			Line:           id,
+			Type:           "land",
+			Terrain:        "plains",
+			Continent:      1,
+			PixelCoordsMap: make(map[image.Point]bool),
+			AdjacentTo:     make(map[int]*Province),
+			ConnectedTo:    make(map[int]*Province),
+			ImpassableTo:   make(map[int]*Province),
+		}
+		if typ, ok := types[id]; ok {
+			p.Type = typ
+			if typ != "land" {
+				p.Terrain = "ocean"
+				p.Continent = 0
+			}
+		}
+		provincesIDMap[id] = p
+		return p
+	}
+	for id := range types {
+		province(id)
+	}
+
+	at := func(x, y int) *Province {
+		if y >= len(rows) || x >= len(rows[y]) || rows[y][x] == '.' {
+			return nil
+		}
+		return province(int(rows[y][x] - '0'))
+	}
+	for y, row := range rows {
+		for x := range row {
+			p := at(x, y)
+			if p == nil {
+				continue
+			}
+			p.PixelCoords = append(p.PixelCoords, image.Point{x, y})
+			p.PixelCoordsMap[image.Point{x, y}] = true
+			for _, n := range []*Province{at(x+1, y), at(x, y+1)} {
+				if n != nil && n != p {
+					p.AdjacentTo[n.ID] = n
+					n.AdjacentTo[p.ID] = p
+				}
+			}
+		}
+	}
+	if len(rows) > 0 {
+		provincesImageSize = image.Rect(0, 0, len(rows[0]), len(rows))
+	}
+}
+
+// RunValidation runs the validate command and returns the lines of its report.
+func runValidation(t *testing.T, validate func(fileName string, render bool) error) []string {
+	err := validate("issues.txt", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(outputPath, "issues.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+}
+
+// CompareIssues reports the difference between the report lines and the wanted issues.
+func compareIssues(t *testing.T, name string, got, want []string) {
+	t.Helper()
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("%v: got issues:\n%v\nwant:\n%v", name, strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestValidateDefinitions(t *testing.T) {
+	tests := []struct {
+		name   string
+		types  map[int]string
+		rows   []string
+		setup  func()
+		issues []string
+	}{
+		{
+			name:  "valid",
+			types: map[int]string{3: "sea", 4: "lake"},
+			rows:  []string{"1133", "2244"},
+		},
+		{
+			name:   "province without pixels",
+			types:  map[int]string{5: "land"},
+			rows:   []string{"12"},
+			issues: []string{"definition.csv:5: province 5 has no pixels in provinces.bmp [provinces 5]"},
+		},
+		{
+			name:   "invalid type",
+			types:  map[int]string{2: "hills"},
+			rows:   []string{"122"},
+			issues: []string{`definition.csv:2: province 2 has invalid type "hills" [provinces 2] at (1,0), (2,0)`},
+		},
+		{
+			name:   "unknown terrain",
+			rows:   []string{"12"},
+			setup:  func() { provincesIDMap[1].Terrain = "swamp" },
+			issues: []string{`definition.csv:1: province 1 has unknown terrain "swamp" [provinces 1] at (0,0)`},
+		},
+		{
+			name:   "no terrain categories",
+			rows:   []string{"12"},
+			setup:  func() { provincesIDMap[1].Terrain = "swamp"; terrainCategoriesMap = map[string]*TerrainCategory{} },
+			issues: nil,
+		},
+		{
+			name: "parse issues come first",
+			rows: []string{"12"},
+			setup: func() {
+				addValidationIssue(validationIssue{Check: "definitions", Pos: filePos{Path: "provinces.bmp"}, Points: []image.Point{{1, 0}}, Message: "color 1;2;3 has no definition (1 pixels)"})
+				addValidationIssue(validationIssue{Check: "pixels", Message: "other check"})
+				provincesIDMap[2].Type = "river"
+			},
+			issues: []string{
+				"provinces.bmp: color 1;2;3 has no definition (1 pixels) at (1,0)",
+				`definition.csv:2: province 2 has invalid type "river" [provinces 2] at (1,0)`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupValidationTest(t, tt.types, tt.rows...)
+			if tt.setup != nil {
+				tt.setup()
+			}
+			compareIssues(t, tt.name, runValidation(t, validateDefinitions), tt.issues)
+		})
+	}
+}
+
+func TestValidatePixels(t *testing.T) {
+	tests := []struct {
+		name   string
+		rows   []string
+		issues []string
+	}{
+		{
+			name: "valid",
+			rows: []string{"112", "112", "332"},
+		},
+		{
+			name: "four provinces",
+			rows: []string{"1122", "1122", "3344", "3344"},
+			issues: []string{
+				"x-crossing of four provinces [provinces 1, 2, 3, 4] at (1,1), (2,1), (1,2), (2,2)",
+				"provinces 1 and 4 only touch diagonally [provinces 1, 4] at (1,1)",
+				"provinces 2 and 3 only touch diagonally [provinces 2, 3] at (2,1)",
+			},
+		},
+		{
+			name:   "checker pattern",
+			rows:   []string{"1122", "1122", "2211", "2211"},
+			issues: []string{"x-crossing of provinces 1 and 2 [provinces 1, 2] at (1,1), (2,1), (1,2), (2,2)"},
+		},
+		{
+			name:   "diagonal contact",
+			rows:   []string{"113", "123", "223"},
+			issues: nil,
+		},
+		{
+			name:   "corner only",
+			rows:   []string{"1133", "1133", "3322", "3322"},
+			issues: []string{"provinces 1 and 2 only touch diagonally [provinces 1, 2] at (1,1)"},
+		},
+		{
+			name:   "single-pixel islands",
+			rows:   []string{"1111", "1211", "1112"},
+			issues: []string{"province 2 has single-pixel islands [provinces 2] at (1,1), (3,2)"},
+		},
+		{
+			name:   "pixels without a province",
+			rows:   []string{"11.", "1.2", ".22"},
+			issues: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupValidationTest(t, nil, tt.rows...)
+			compareIssues(t, tt.name, runValidation(t, validatePixels), tt.issues)
+		})
+	}
+}
+
+// AddTestState adds a state with the provinces to statesMap,
+// defined on the first line of states/<id>.txt.
+func addTestState(id int, provinceIDs ...int) *State {
+	s := &State{
+		ID:             id,
+		Pos:            filePos{Path: fmt.Sprintf("states/%v.txt", id), Line: 1, Column: 1},
+		Provinces:      make(map[int]*Province),
+		PixelCoordsMap: make(map[image.Point]bool),
+	}
+	for _, pID := range provinceIDs {
+		p := provincesIDMap[pID]
+		s.Provinces[pID] = p
+		for _, c := range p.PixelCoords {
+			s.PixelCoords = append(s.PixelCoords, c)
+			s.PixelCoordsMap[c] = true
+		}
+	}
+	statesMap[id] = s
+	return s
+}
+
+func TestValidateContiguity(t *testing.T) {
+	tests := []struct {
+		name   string
+		rows   []string
+		states [][]int // Provinces of states 1, 2 and so on.
+		issues []string
+	}{
+		{
+			name:   "valid",
+			rows:   []string{"1122", "1333"},
+			states: [][]int{{1, 2}, {3}},
+		},
+		{
+			name:   "split province",
+			rows:   []string{"1121", "2222", "1111"},
+			states: [][]int{{1, 2}},
+			issues: []string{"province 1 is split into 3 parts: 4 px in (0,2)-(3,2); 2 px in (0,0)-(1,0); 1 px in (3,0)-(3,0) [provinces 1] at (0,0), (1,0), (3,0)"},
+		},
+		{
+			name:   "diagonal pixels",
+			rows:   []string{"12", "21"},
+			issues: []string{"province 1 is split into 2 parts: 1 px in (0,0)-(0,0); 1 px in (1,1)-(1,1) [provinces 1] at (1,1)", "province 2 is split into 2 parts: 1 px in (1,0)-(1,0); 1 px in (0,1)-(0,1) [provinces 2] at (0,1)"},
+		},
+		{
+			name:   "split state",
+			rows:   []string{"1233", "1233"},
+			states: [][]int{{1, 3}, {2}},
+			issues: []string{"state 1 is split into 2 parts: 4 px in (2,0)-(3,1); 2 px in (0,0)-(0,1) at (0,0), (0,1)"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupValidationTest(t, nil, tt.rows...)
+			for i, provinces := range tt.states {
+				addTestState(i+1, provinces...)
+			}
+			compareIssues(t, tt.name, runValidation(t, validateContiguity), tt.issues)
+		})
+	}
+}
+
+func TestValidateAssignments(t *testing.T) {
+	tests := []struct {
+		name    string
+		types   map[int]string
+		rows    []string
+		states  [][]int // Provinces of states 1, 2 and so on.
+		regions [][]int // Provinces of strategic regions 1, 2 and so on.
+		setup   func()
+		issues  []string
+	}{
+		{
+			name:    "valid",
+			types:   map[int]string{3: "sea", 4: "lake"},
+			rows:    []string{"1134", "2234"},
+			states:  [][]int{{1}, {2, 4}},
+			regions: [][]int{{1, 2}, {3, 4}},
+		},
+		{
+			name:    "sea province in a state",
+			types:   map[int]string{3: "sea"},
+			rows:    []string{"123"},
+			states:  [][]int{{1, 2, 3}},
+			regions: [][]int{{1, 2, 3}},
+			issues:  []string{"states/1.txt:1:1: state 1 contains sea province 3 [provinces 3]"},
+		},
+		{
+			name:    "several continents",
+			rows:    []string{"123"},
+			states:  [][]int{{1, 2, 3}},
+			regions: [][]int{{1, 2, 3}},
+			setup:   func() { provincesIDMap[3].Continent = 2 },
+			issues:  []string{"states/1.txt:1:1: state 1 has provinces on continents 1, 2 [provinces 1, 2, 3]"},
+		},
+		{
+			name:    "missing state and region",
+			types:   map[int]string{3: "sea"},
+			rows:    []string{"123"},
+			states:  [][]int{{1}},
+			regions: [][]int{{1}},
+			issues: []string{
+				"definition.csv:2: land province 2 is not in any state [provinces 2]",
+				"definition.csv:2: land province 2 is not in any strategic region [provinces 2]",
+				"definition.csv:3: sea province 3 is not in any strategic region [provinces 3]",
+			},
+		},
+		{
+			name:    "several states and regions",
+			rows:    []string{"12"},
+			states:  [][]int{{1, 2}, {2}},
+			regions: [][]int{{1}, {1, 2}, {2}},
+			issues: []string{
+				"regions/2.txt:1:1: province 1 is in several strategic regions: 1 (regions/1.txt:1:1), 2 (regions/2.txt:1:1) [provinces 1]",
+				"states/2.txt:1:1: province 2 is in several states: 1 (states/1.txt:1:1), 2 (states/2.txt:1:1) [provinces 2]",
+				"regions/3.txt:1:1: province 2 is in several strategic regions: 2 (regions/2.txt:1:1), 3 (regions/3.txt:1:1) [provinces 2]",
+			},
+		},
+		{
+			name:    "parse issues come first",
+			rows:    []string{"1"},
+			states:  [][]int{{1}},
+			regions: [][]int{{1}},
+			setup: func() {
+				addValidationIssue(validationIssue{Check: "assignments", Pos: filePos{Path: "states/2.txt", Line: 1, Column: 1}, Message: "duplicate state ID 1, first defined at states/1.txt:1:1"})
+			},
+			issues: []string{"states/2.txt:1:1: duplicate state ID 1, first defined at states/1.txt:1:1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupValidationTest(t, tt.types, tt.rows...)
+			for i, provinces := range tt.states {
+				addTestState(i+1, provinces...)
+			}
+			for i, provinces := range tt.regions {
+				r := &StrategicRegion{ID: i + 1, Pos: filePos{Path: fmt.Sprintf("regions/%v.txt", i+1), Line: 1, Column: 1}, Provinces: make(map[int]*Province)}
+				for _, pID := range provinces {
+					r.Provinces[pID] = provincesIDMap[pID]
+				}
+				strategicRegionMap[r.ID] = r
+			}
+			if tt.setup != nil {
+				tt.setup()
+			}
+			compareIssues(t, tt.name, runValidation(t, validateAssignments), tt.issues)
+		})
+	}
+}
+
+func TestParseStateAndStrategicRegionFiles(t *testing.T) {
+	files := map[string]string{
+		"1.txt": "%[1]v = { id = 1 provinces = { 1 2 } }",
+		"2.txt": "%[1]v = {\n\tid = 1\n\tprovinces = { 3 }\n}",
+		"3.txt": "%[1]v = { id = 2",
+		"4.txt": "# no %[1]v here\n",
+		"5.txt": "%[1]v = { id = x }\n%[1]v = { id = 3 provinces = { 9 } }",
+	}
+	kinds := []struct {
+		key   string
+		name  string
+		path  *string
+		parse func() error
+		ids   func() []int
+	}{
+		{"state", "state", &statesPath, parseStateFiles, func() []int { return sortedKeySliceFromStateMap(statesMap) }},
+		{"strategic_region", "strategic region", &strategicRegionPath, parseStrategicRegionFiles, func() []int { return sortedKeySliceFromStrategicRegionMap(strategicRegionMap) }},
+	}
+
+	for _, k := range kinds {
+		for _, lenient := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%v lenient %v", k.key, lenient), func(t *testing.T) {
+				setupValidationTest(t, nil, "123")
+				lenientParsing = lenient
+				dir := t.TempDir()
+				for name, src := range files {
+					err := ioutil.WriteFile(filepath.Join(dir, name), []byte(fmt.Sprintf(src, k.key)), 0664)
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+				oldPath := *k.path
+				defer func() { *k.path = oldPath }()
+				*k.path = dir
+				rel := func(s string) string { return strings.ReplaceAll(s, dir+string(filepath.Separator), "") }
+
+				err := k.parse()
+				if !lenient {
+					want := fmt.Sprintf("2.txt:1:1: duplicate %v ID 1, first defined at 1.txt:1:1", k.name)
+					if err == nil || rel(err.Error()) != want {
+						t.Errorf("got error %v, want %q", err, want)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				if got := joinInts(k.ids()); got != "1, 3" {
+					t.Errorf("got IDs %v, want 1, 3", got)
+				}
+				if got := joinInts(sortedKeySliceFromProvinceMap(provincesIDMap)); got != "1, 2, 3" {
+					t.Errorf("got provinces %v", got)
+				}
+				var issues []string
+				for _, i := range issuesOf("assignments") {
+					issues = append(issues, rel(i.String()))
+				}
+				compareIssues(t, k.key, issues, []string{
+					fmt.Sprintf("2.txt:1:1: duplicate %v ID 1, first defined at 1.txt:1:1", k.name),
+					"3.txt:1:1: missing closing bracket",
+					fmt.Sprintf("4.txt:1:1: no %v found", k.key),
+					fmt.Sprintf(`5.txt:1:%v: id: expected integer, found "x"`, len(k.key)+6),
+					fmt.Sprintf("5.txt:2:%v: %v references unknown province 9", len(k.key)+27, k.key),
+				})
+			})
+		}
+	}
+}
+
+// WriteTestTerrainImage writes terrain.bmp with palette indices drawn by rows of digits
+// into a temporary folder, palette index 0 is plains, 1 is forest and 2 is ocean.
+func writeTestTerrainImage(t *testing.T, rows ...string) {
+	oldPath := terrainPath
+	t.Cleanup(func() { terrainPath = oldPath })
+
+	terrainCategoriesMap["forest"] = &TerrainCategory{Name: "forest"}
+	terrainIndexMap = map[uint8]string{0: "plains", 1: "forest", 2: "ocean"}
+	palette := color.Palette{color.RGBA{0, 255, 0, 255}, color.RGBA{0, 128, 0, 255}, color.RGBA{0, 0, 255, 255}}
+	for len(palette) < 16 {
+		palette = append(palette, color.RGBA{255, 255, 255, 255})
+	}
+	img := image.NewPaletted(image.Rect(0, 0, len(rows[0]), len(rows)), palette)
+	for y, row := range rows {
+		for x := range row {
+			img.SetColorIndex(x, y, row[x]-'0')
+		}
+	}
+
+	terrainPath = filepath.Join(t.TempDir(), "terrain.bmp")
+	f, err := os.Create(terrainPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	err = bmp.Encode(f, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateTerrain(t *testing.T) {
+	types := map[int]string{3: "sea", 4: "lake"}
+	rows := []string{"12224", "12234"}
+	tests := []struct {
+		name    string
+		terrain []string
+		setup   func()
+		issues  []string
+	}{
+		{
+			name: "coastal flags",
+			setup: func() {
+				provincesIDMap[1].IsCoastal = true
+				provincesIDMap[4].IsCoastal = true
+			},
+			issues: []string{
+				"definition.csv:1: land province 1 is coastal true, found false [provinces 1]",
+				"definition.csv:2: land province 2 is coastal false, found true [provinces 2]",
+				"definition.csv:3: sea province 3 is coastal false, found true [provinces 3]",
+				"definition.csv:4: lake province 4 is coastal true, found false [provinces 4]",
+			},
+		},
+		{
+			name: "valid",
+			setup: func() {
+				provincesIDMap[2].IsCoastal = true
+				provincesIDMap[3].IsCoastal = true
+			},
+			terrain: []string{"00000", "00002"},
+		},
+		{
+			name: "terrain",
+			setup: func() {
+				provincesIDMap[2].IsCoastal = true
+				provincesIDMap[3].IsCoastal = true
+			},
+			// Only land provinces are checked, sea province 3 stays ocean.
+			terrain: []string{"11101", "10111"},
+			issues: []string{
+				"definition.csv:1: province 1 has terrain plains, found forest [provinces 1]",
+				"definition.csv:2: province 2 has terrain plains, found forest [provinces 2]",
+			},
+		},
+		{
+			name: "unknown palette indices",
+			setup: func() {
+				provincesIDMap[2].IsCoastal = true
+				provincesIDMap[3].IsCoastal = true
+			},
+			// Pixels of unknown indices are skipped, even if most pixels of province 2 have them.
+			terrain: []string{"59990", "10000"},
+			issues:  []string{"definition.csv:1: province 1 has terrain plains, found forest [provinces 1]"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupValidationTest(t, types, rows...)
+			if tt.terrain != nil {
+				writeTestTerrainImage(t, tt.terrain...)
+			}
+			if tt.setup != nil {
+				tt.setup()
+			}
+			validate := func(fileName string, render bool) error { return validateTerrain(fileName, render, false) }
+			compareIssues(t, tt.name, runValidation(t, validate), tt.issues)
+		})
+	}
+}
+
+func TestValidateTerrainFix(t *testing.T) {
+	setupValidationTest(t, map[int]string{3: "sea"}, "1223", "1223")
+	writeTestTerrainImage(t, "1100", "1000")
+	dir := t.TempDir()
+	definitionsPath = filepath.Join(dir, "definition.csv")
+	err := ioutil.WriteFile(definitionsPath, []byte("1;0;0;0;land;false;plains;1\r\n2;0;0;0;land;false;forest;1\r\n3;0;0;0;sea;true;ocean;0\r\n"), 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provincesIDMap[2].Terrain = "forest"
+	provincesIDMap[3].IsCoastal = true
+
+	err = validateTerrain("issues.txt", false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(outputPath, "definition.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "1;0;0;0;land;false;forest;1\r\n2;0;0;0;land;true;plains;1\r\n3;0;0;0;sea;true;ocean;0\r\n"
+	if string(b) != want {
+		t.Errorf("got definition.csv:\n%q\nwant:\n%q", b, want)
+	}
+}
+
+func TestTerrainFilesLoadedOnce(t *testing.T) {
+	setupValidationTest(t, nil, "11")
+	terrainLoaded = false
+	terrainCategoriesMap = make(map[string]*TerrainCategory)
+	for name, src := range map[string]string{
+		"a.txt": "categories = plains",
+		"b.txt": "categories = { plains = { } ocean = { is_water = yes } }",
+	} {
+		err := ioutil.WriteFile(filepath.Join(terrainCategoriesPath, name), []byte(src), 0664)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Both commands use the terrain files, the broken one is reported once.
+	err := validateTerrain("terrain.txt", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := runValidation(t, validateDefinitions)
+	want := []string{filepath.ToSlash(filepath.Join(terrainCategoriesPath, "a.txt")) + ":1:1: categories: expected block"}
+	compareIssues(t, "definitions", got, want)
+	if len(terrainCategoriesMap) != 2 {
+		t.Errorf("got terrain categories %v, want plains and ocean", terrainCategoriesMap)
+	}
+}